@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bcaldwell/kube-deploy/pkg/deploy"
+	"github.com/bcaldwell/kube-deploy/pkg/lib/logger"
+)
+
+// monorepoFlags select the config folders to deploy from a folder of config folders instead of using configFolder
+type monorepoFlags struct {
+	root         string
+	changedSince string
+}
+
+// deployChangedFolders deploys every selected config folder under root with the other flags from d
+func deployChangedFolders(d *deploy.Deploy, target string, f monorepoFlags) error {
+	folders, err := deploy.ChangedFolders(f.root, f.changedSince)
+	if err != nil {
+		return err
+	}
+
+	if len(folders) == 0 {
+		logger.Log("no config folders to deploy")
+		return nil
+	}
+
+	env := os.Environ()
+
+	for _, folder := range folders {
+		logger.Log("deploying %s", folder.Folder)
+
+		folderDeploy := *d
+		folderDeploy.ConfigFolder = folder.Folder
+
+		err = folderDeploy.Run(target)
+
+		// vars are exported for each deploy, they can't leak into the next folder
+		resetEnv(env)
+
+		if err != nil {
+			return fmt.Errorf("failed to deploy %s: %w", folder.Folder, err)
+		}
+	}
+
+	return nil
+}
+
+func resetEnv(env []string) {
+	os.Clearenv()
+
+	for _, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			os.Setenv(parts[0], parts[1])
+		}
+	}
+}
@@ -1,30 +1,145 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/bcaldwell/kube-deploy/pkg/deploy"
 	"github.com/bcaldwell/kube-deploy/pkg/lib/logger"
 )
 
+var errConfigFolderRequired = errors.New("--configFolder is required")
+
+var errRootRequired = errors.New("--changed-since requires --root")
+
 func main() {
-	d := deploy.Deploy{}
+	args := os.Args[1:]
+	command := "deploy"
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	var err error
+
+	switch command {
+	case "deploy":
+		err = deployCommand(args)
+	case "explain":
+		err = explainCommand(args)
+	case "secrets":
+		err = secretsCommand(args)
+	default:
+		err = fmt.Errorf("unknown command %s, expected one of deploy, explain or secrets", command)
+	}
+
+	if errors.Is(err, errConfigFolderRequired) || errors.Is(err, errRootRequired) {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if err != nil {
+		logger.Log("Error running %s %s", command, err)
+		os.Exit(1)
+	}
+}
+
+// parseDeployFlags parses the deploy flags, monorepo flags are only parsed when monorepo is set
+func parseDeployFlags(command string, args []string, monorepo *monorepoFlags) (*deploy.Deploy, string, error) {
+	d := &deploy.Deploy{}
 	target := ""
 
-	flag.StringVar(&d.ConfigFolder, "configFolder", "", "")
-	flag.StringVar(&target, "target", "", "")
-	flag.Parse()
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.StringVar(&d.ConfigRepo, "configRepo", "", "git repo to clone the config from, configFolder is relative to the repo root")
+	flags.StringVar(&d.ConfigFolder, "configFolder", "", "")
+	flags.StringVar(&d.ConfigRef, "ref", "", "branch, tag or commit sha of configRepo to deploy, defaults to the default branch")
+	flags.IntVar(&d.ConfigDepth, "depth", 0, "number of commits to fetch when cloning a branch or tag of configRepo, everything is fetched when unset")
+	flags.StringVar(&d.ConfigCacheDir, "cacheDir", os.Getenv("KUBE_DEPLOY_CACHE_DIR"), "directory to cache configRepo clones in between runs, defaults to KUBE_DEPLOY_CACHE_DIR")
+	flags.StringVar(&d.ConfigKeyring, "verifyKeyring", "", "armored gpg keyring, configRepo must be at a commit or tag signed by one of its keys")
+	flags.StringVar(&d.ConfigAllowedSigners, "allowedSigners", "", "ssh allowed signers file, configRepo must be at a commit or tag signed by one of its keys")
+	flags.StringVar(&target, "target", "", "")
+	flags.StringVar(&d.KubeContext, "kubeContext", "", "context in the kube config to deploy to")
+	flags.BoolVar(&d.DryRun, "dryRun", false, "validate everything client side without changing the cluster")
+	flags.StringVar(&d.EjsonKeyDir, "keydir", "", "directory with ejson private keys, defaults to EJSON_KEYDIR or /opt/ejson/keys")
+
+	if monorepo != nil {
+		flags.StringVar(&monorepo.root, "root", "", "deploy the config folders under root, every folder with a metadata.yml, instead of configFolder")
+		flags.StringVar(&monorepo.changedSince, "changed-since", "", "only deploy config folders under root affected by changes since this git ref")
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if monorepo != nil && monorepo.changedSince != "" && monorepo.root == "" {
+		return nil, "", errRootRequired
+	}
+
+	if monorepo != nil && monorepo.root != "" {
+		if d.ConfigFolder != "" || d.ConfigRepo != "" {
+			return nil, "", errors.New("--root can not be used with --configFolder or --configRepo")
+		}
+
+		return d, target, nil
+	}
 
 	if d.ConfigFolder == "" {
-		fmt.Println("--configFolder is required")
-		return
+		return nil, "", errConfigFolderRequired
 	}
 
-	err := d.Run(target)
+	return d, target, nil
+}
+
+func deployCommand(args []string) error {
+	monorepo := monorepoFlags{}
+
+	d, target, err := parseDeployFlags("deploy", args, &monorepo)
 	if err != nil {
-		logger.Log("Error deploying %s", err)
-		os.Exit(1)
+		return err
+	}
+
+	if monorepo.root != "" {
+		return deployChangedFolders(d, target, monorepo)
 	}
+
+	return d.Run(target)
+}
+
+func explainCommand(args []string) error {
+	d, target, err := parseDeployFlags("explain", args, nil)
+	if err != nil {
+		return err
+	}
+
+	values, err := d.Explain(target)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range values {
+		b, _ := json.Marshal(v.Value)
+		fmt.Printf("%s = %s (%s)\n", v.Key, b, provenance(v))
+	}
+
+	return nil
+}
+
+// provenance describes the layer a value came from and the layers it overrides
+func provenance(v deploy.ExplainedValue) string {
+	if len(v.Layers) == 0 {
+		return "resolved"
+	}
+
+	p := "from " + v.Layer
+
+	if len(v.Layers) > 1 {
+		p += ", overriding " + strings.Join(v.Layers[:len(v.Layers)-1], ", ")
+	}
+
+	return p
 }
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/bcaldwell/kube-deploy/pkg/deploy"
+	"github.com/bcaldwell/kube-deploy/pkg/lib/ejsonsecret"
+	"github.com/bcaldwell/kube-deploy/pkg/lib/logger"
+)
+
+const secretsUsage = "usage: kube-deploy secrets <new|edit|rotate|view> [flags] <files>"
+
+func secretsCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New(secretsUsage)
+	}
+
+	command, args := args[0], args[1:]
+
+	switch command {
+	case "new":
+		return secretsNewCommand(args)
+	case "edit":
+		return secretsEditCommand(args)
+	case "rotate":
+		return secretsRotateCommand(args)
+	case "view":
+		return secretsViewCommand(args)
+	}
+
+	return fmt.Errorf("unknown secrets command %s, %s", command, secretsUsage)
+}
+
+// secretsFlags are shared by every secrets command, the config folder and target are only used for defaults
+type secretsFlags struct {
+	*flag.FlagSet
+	configFolder string
+	target       string
+	keyDir       string
+}
+
+func newSecretsFlags(command string) *secretsFlags {
+	f := &secretsFlags{FlagSet: flag.NewFlagSet("secrets "+command, flag.ExitOnError)}
+	f.StringVar(&f.configFolder, "configFolder", "", "config folder used for the target's defaults")
+	f.StringVar(&f.target, "target", "", "")
+	f.StringVar(&f.keyDir, "keydir", "", "directory with ejson private keys, defaults to EJSON_KEYDIR or /opt/ejson/keys")
+
+	return f
+}
+
+// deploy returns the configured deploy for the target, nil when no config folder is set
+func (f *secretsFlags) deploy() (*deploy.Deploy, error) {
+	if f.configFolder == "" {
+		return nil, nil
+	}
+
+	d := &deploy.Deploy{ConfigFolder: f.configFolder, EjsonKeyDir: f.keyDir}
+
+	err := d.Configure(f.target)
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (f *secretsFlags) provider() (ejsonsecret.Provider, error) {
+	return ejsonsecret.NewProvider(f.keyDir)
+}
+
+// publicKey returns the public key from the flag or generates a new keypair when generate is set
+func (f *secretsFlags) publicKey(publicKey string, generate bool, writeKey bool) (string, error) {
+	if publicKey != "" && generate {
+		return "", errors.New("only one of --publicKey or --generate can be set")
+	}
+
+	if publicKey != "" {
+		return publicKey, nil
+	}
+
+	if !generate {
+		return "", errors.New("--publicKey or --generate is required")
+	}
+
+	keyDir := f.keyDir
+	if keyDir == "" {
+		p, err := f.provider()
+		if err != nil {
+			return "", err
+		}
+
+		keyDir = p.KeyDir
+	}
+
+	publicKey, privateKey, err := ejsonsecret.GenerateKey(keyDir, writeKey)
+	if err != nil {
+		return "", err
+	}
+
+	if privateKey != "" {
+		fmt.Fprintf(os.Stderr, "generated private key %s, store it somewhere safe, it won't be shown again\n", privateKey)
+	} else {
+		logger.Log("wrote private key for %s to %s", publicKey, keyDir)
+	}
+
+	return publicKey, nil
+}
+
+func secretsNewCommand(args []string) error {
+	f := newSecretsFlags("new")
+
+	var name, namespace, publicKey string
+
+	var generate, writeKey bool
+
+	f.StringVar(&name, "name", "", "name of the secret, defaults to the file name")
+	f.StringVar(&namespace, "namespace", "", "namespace of the secret, defaults to the target's namespace")
+	f.StringVar(&publicKey, "publicKey", "", "public key to encrypt with")
+	f.BoolVar(&generate, "generate", false, "generate a new keypair")
+	f.BoolVar(&writeKey, "writeKey", false, "write the generated private key to the key dir instead of printing it")
+
+	err := f.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if f.NArg() < 1 {
+		return errors.New("usage: kube-deploy secrets new [flags] <file> [key=value ...]")
+	}
+
+	file := f.Arg(0)
+
+	if _, err := os.Stat(file); err == nil {
+		return fmt.Errorf("%s already exists, use secrets edit to change it", file)
+	}
+
+	data := make(map[string]string)
+
+	for _, kv := range f.Args()[1:] {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid secret value %s, expected key=value", kv)
+		}
+
+		data[parts[0]] = parts[1]
+	}
+
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+
+	if namespace == "" {
+		d, err := f.deploy()
+		if err != nil {
+			return err
+		}
+
+		if d != nil {
+			namespace = d.Namespace
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+
+	publicKey, err = f.publicKey(publicKey, generate, writeKey)
+	if err != nil {
+		return err
+	}
+
+	content, err := ejsonsecret.NewDocument(publicKey, name, namespace, data)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(file, content, 0644)
+	if err != nil {
+		return err
+	}
+
+	logger.Log("created secret %s in %s", name, file)
+
+	return nil
+}
+
+func secretsEditCommand(args []string) error {
+	f := newSecretsFlags("edit")
+
+	err := f.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if f.NArg() != 1 {
+		return errors.New("usage: kube-deploy secrets edit [flags] <file>")
+	}
+
+	p, err := f.provider()
+	if err != nil {
+		return err
+	}
+
+	return p.Edit(f.Arg(0), openEditor)
+}
+
+// openEditor writes plaintext to a temp file only readable by the current user, opens $EDITOR and returns the edited content
+func openEditor(plaintext []byte) ([]byte, error) {
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+
+	tmp, err := ioutil.TempFile("", "kube-deploy-secret-*.json")
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(plaintext)
+	tmp.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// EDITOR can include arguments, like "code --wait"
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", tmp.Name())
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("editor %s failed: %w", editor, err)
+	}
+
+	return ioutil.ReadFile(tmp.Name())
+}
+
+func secretsRotateCommand(args []string) error {
+	f := newSecretsFlags("rotate")
+
+	var publicKey string
+
+	var generate, writeKey bool
+
+	f.StringVar(&publicKey, "publicKey", "", "public key to re-encrypt with")
+	f.BoolVar(&generate, "generate", false, "generate a new keypair")
+	f.BoolVar(&writeKey, "writeKey", false, "write the generated private key to the key dir instead of printing it")
+
+	err := f.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	paths := f.Args()
+	if len(paths) == 0 {
+		if f.configFolder == "" {
+			return errors.New("usage: kube-deploy secrets rotate [flags] <files or folders>, defaults to --configFolder")
+		}
+
+		paths = []string{f.configFolder}
+	}
+
+	files, err := findEjsonFiles(paths)
+	if err != nil {
+		return err
+	}
+
+	if len(files) == 0 {
+		return fmt.Errorf("no ejson files found in %s", strings.Join(paths, ", "))
+	}
+
+	p, err := f.provider()
+	if err != nil {
+		return err
+	}
+
+	publicKey, err = f.publicKey(publicKey, generate, writeKey)
+	if err != nil {
+		return err
+	}
+
+	// decrypt everything before writing anything so a missing key doesn't leave the tree half rotated
+	for _, file := range files {
+		_, err = p.View(file, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, file := range files {
+		err = p.Rotate(file, publicKey)
+		if err != nil {
+			return fmt.Errorf("failed to rotate %s: %w", file, err)
+		}
+
+		logger.Log("rotated %s to %s", file, publicKey)
+	}
+
+	return nil
+}
+
+func findEjsonFiles(paths []string) ([]string, error) {
+	files := []string{}
+
+	for _, p := range paths {
+		err := filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() && info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+
+			if !info.IsDir() && filepath.Ext(path) == ".ejson" {
+				files = append(files, path)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+func secretsViewCommand(args []string) error {
+	f := newSecretsFlags("view")
+
+	var reveal bool
+
+	f.BoolVar(&reveal, "reveal", false, "print decrypted values instead of redacting them")
+
+	err := f.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if f.NArg() < 1 {
+		return errors.New("usage: kube-deploy secrets view [flags] <files>")
+	}
+
+	p, err := f.provider()
+	if err != nil {
+		return err
+	}
+
+	for _, file := range f.Args() {
+		content, err := p.View(file, reveal)
+		if err != nil {
+			return err
+		}
+
+		if f.NArg() > 1 {
+			fmt.Printf("# %s\n", file)
+		}
+
+		fmt.Println(strings.TrimSpace(string(content)))
+	}
+
+	return nil
+}
@@ -0,0 +1,316 @@
+package deploy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bcaldwell/kube-deploy/pkg/lib/logger"
+	"github.com/spf13/afero"
+)
+
+// number of changed files listed in the reason a folder was selected
+const maxListedChanges = 3
+
+// ChangedFolder is a config folder selected for deploying along with why it was selected
+type ChangedFolder struct {
+	// config folder path, it is under the root the folders were found in
+	Folder  string
+	Reasons []string
+}
+
+// ChangedFolders finds the config folders under root, which are folders with a metadata.yml, that are affected by changes since ref.
+// a folder is affected when a file in it, a metadata file it includes, a global_vars.yml it uses, a file a valueFrom var reads
+// or a helm values file it references changed. changes are everything since the merge base of ref and HEAD, including uncommitted and untracked files.
+// every config folder is returned when ref is empty
+func ChangedFolders(root string, ref string) ([]ChangedFolder, error) {
+	gitRoot, err := findTopLevelGitDir(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find git repo for %s: %w", root, err)
+	}
+
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	repoRoot, err := filepath.Rel(gitRoot, absRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := afero.NewBasePathFs(afero.NewOsFs(), gitRoot)
+
+	// the deploy reads the global vars file relative to the git repo, the same as the changed files
+	globalVars := globalVarsEnvFile()
+
+	folders, err := findConfigFolders(fs, filepath.ToSlash(repoRoot))
+	if err != nil {
+		return nil, err
+	}
+
+	var changed map[string]bool
+
+	if ref != "" {
+		changed, err = changedFiles(gitRoot, ref)
+		if err != nil {
+			return nil, err
+		}
+
+		logger.Log("%d files changed since %s", len(changed), ref)
+	}
+
+	selected := []ChangedFolder{}
+
+	for _, folder := range folders {
+		reasons := []string{"deploying every config folder"}
+
+		if ref != "" {
+			reasons, err = changeReasons(fs, folder, globalVars, changed)
+			if err != nil {
+				return nil, fmt.Errorf("failed to find the files %s depends on: %w", folder, err)
+			}
+		}
+
+		if len(reasons) == 0 {
+			logger.Log("skipping %s, nothing it depends on changed since %s", folder, ref)
+			continue
+		}
+
+		rel, err := filepath.Rel(repoRoot, filepath.FromSlash(folder))
+		if err != nil {
+			return nil, err
+		}
+
+		selected = append(selected, ChangedFolder{
+			Folder:  filepath.Join(root, rel),
+			Reasons: reasons,
+		})
+
+		logger.Log("selected %s:", folder)
+
+		for _, reason := range reasons {
+			logger.Log("  %s", reason)
+		}
+	}
+
+	logger.Log("selected %d of %d config folders under %s", len(selected), len(folders), root)
+
+	return selected, nil
+}
+
+// findConfigFolders returns every folder under root with a metadata.yml
+func findConfigFolders(fs afero.Fs, root string) ([]string, error) {
+	folders := []string{}
+
+	err := afero.Walk(fs, root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+
+		if !info.IsDir() && info.Name() == "metadata.yml" {
+			folders = append(folders, path.Dir(file))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find config folders in %s: %w", root, err)
+	}
+
+	return folders, nil
+}
+
+// changeReasons returns why folder is affected by the changed files, it is empty when the folder isn't affected
+func changeReasons(fs afero.Fs, folder string, globalVars string, changed map[string]bool) ([]string, error) {
+	reasons := []string{}
+
+	folderChanges := []string{}
+
+	for file := range changed {
+		if inFolder(file, folder) {
+			folderChanges = append(folderChanges, file)
+		}
+	}
+
+	if len(folderChanges) != 0 {
+		sort.Strings(folderChanges)
+
+		listed := folderChanges
+		if len(listed) > maxListedChanges {
+			listed = listed[:maxListedChanges]
+		}
+
+		reason := "changed " + strings.Join(listed, ", ")
+		if len(folderChanges) > len(listed) {
+			reason += fmt.Sprintf(" and %d more files", len(folderChanges)-len(listed))
+		}
+
+		reasons = append(reasons, reason)
+	}
+
+	dependencies, err := folderDependencies(fs, folder, globalVars)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dependency := range dependencies {
+		if changed[dependency.file] && !inFolder(dependency.file, folder) {
+			reasons = append(reasons, fmt.Sprintf("%s %s changed", dependency.kind, dependency.file))
+		}
+	}
+
+	return reasons, nil
+}
+
+type folderDependency struct {
+	kind string
+	file string
+}
+
+// folderDependencies returns the files outside of the folder's own files that the config in folder uses.
+// globalVars is the path in fs of the file set in KUBE_DEPLOY_GLOBAL_VARS, it is empty when none is set
+func folderDependencies(fs afero.Fs, folder string, globalVars string) ([]folderDependency, error) {
+	dependencies := []folderDependency{}
+
+	metadataFile := path.Join(folder, "metadata.yml")
+
+	m, layers, err := loadMetadata(fs, metadataFile, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// every metadata file is a layer, the folder's own metadata.yml is the last one
+	for _, layer := range layers[:len(layers)-1] {
+		dependencies = append(dependencies, folderDependency{kind: "included metadata", file: layer.Name})
+	}
+
+	globalVarsFiles := []string{}
+	for _, dir := range folderHierarchy(folder) {
+		globalVarsFiles = append(globalVarsFiles, path.Join(dir, globalVarsFile))
+	}
+
+	if globalVars != "" {
+		globalVarsFiles = append(globalVarsFiles, globalVars)
+	}
+
+	// vars from every target are included for the same reason as values files
+	vars := []Vars{m.Vars}
+	for _, t := range m.Targets {
+		vars = append(vars, t.Vars)
+	}
+
+	for _, f := range globalVarsFiles {
+		dependencies = append(dependencies, folderDependency{kind: "global vars", file: f})
+
+		globalVars := GlobalVars{}
+
+		err := readAndUnmarshal(fs, &globalVars, f)
+		if errors.Is(err, fileNotFoundErr) {
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to load global vars from %s: %w", f, err)
+		}
+
+		vars = append(vars, globalVars.GlobalVars)
+		for _, targetVars := range globalVars.Targets {
+			vars = append(vars, targetVars)
+		}
+	}
+
+	for _, v := range vars {
+		for _, file := range valueFromFiles(folder, v) {
+			dependencies = append(dependencies, folderDependency{kind: "var file", file: file})
+		}
+	}
+
+	// targets aren't known when selecting folders, so values files from every target are included
+	deployFolders := configureDeployFolders(fs, folder, m.Folders, m.Helm, true)
+
+	for _, t := range m.Targets {
+		helm := t.Helm
+		if helm == nil {
+			helm = m.Helm
+		}
+
+		deployFolders = append(deployFolders, configureDeployFolders(fs, folder, t.Folders, helm, false)...)
+
+		for _, mf := range t.MergeFolders {
+			deployFolders = append(deployFolders, processDeployFolder(folder, 0, m.Helm, mf.DeployFolder))
+		}
+	}
+
+	for _, f := range deployFolders {
+		if f.HelmChart == nil {
+			continue
+		}
+
+		for _, values := range f.HelmChart.ValuesFiles {
+			dependencies = append(dependencies, folderDependency{kind: "helm values file", file: path.Join(f.Path, values)})
+		}
+	}
+
+	return dependencies, nil
+}
+
+func inFolder(file string, folder string) bool {
+	return folder == "." || strings.HasPrefix(file, folder+"/")
+}
+
+// changedFiles returns the files changed since the merge base of ref and HEAD, relative to the root of the git repo
+func changedFiles(gitRoot string, ref string) (map[string]bool, error) {
+	base, err := gitOutput(gitRoot, "merge-base", ref, "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find merge base of %s and HEAD: %w", ref, err)
+	}
+
+	// renames are listed as a delete and an add so the folder a file moved out of is affected too
+	diff, err := gitOutput(gitRoot, "diff", "--name-only", "--no-renames", "-z", strings.TrimSpace(base))
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff against %s: %w", ref, err)
+	}
+
+	untracked, err := gitOutput(gitRoot, "ls-files", "--others", "--exclude-standard", "-z")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list untracked files: %w", err)
+	}
+
+	changed := make(map[string]bool)
+
+	for _, file := range strings.Split(diff+untracked, "\x00") {
+		if file != "" {
+			changed[file] = true
+		}
+	}
+
+	return changed, nil
+}
+
+func gitOutput(dir string, args ...string) (string, error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && stderr.Len() != 0 {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return string(out), err
+}
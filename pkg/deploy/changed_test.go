@@ -0,0 +1,78 @@
+package deploy
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestChangeReasons(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	files := map[string]string{
+		"apps/web/metadata.yml": `include: [../shared/redis.yml]
+namespace: web
+helm: {name: web, valuesFiles: [../../../shared/values.yml]}
+vars:
+  TOKEN: {valueFrom: {file: ../shared/token.txt}}
+`,
+		"apps/web/helmvalues/values.yml": "replicas: 1\n",
+		"apps/shared/redis.yml":          "vars: {REDIS: redis}\n",
+		"apps/shared/token.txt":          "s3cret\n",
+		"apps/global_vars.yml":           "global_vars: {A: a}\n",
+		"shared/values.yml":              "replicas: 2\n",
+		"env/global_vars.yml":            "global_vars: {B: b}\n",
+	}
+
+	for name, content := range files {
+		if err := afero.WriteFile(fs, name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		changed    string
+		globalVars string
+		reason     string
+	}{
+		{name: "file in the folder", changed: "apps/web/helmvalues/values.yml", reason: "changed apps/web/helmvalues/values.yml"},
+		{name: "included metadata", changed: "apps/shared/redis.yml", reason: "included metadata apps/shared/redis.yml changed"},
+		{name: "global vars in a parent folder", changed: "apps/global_vars.yml", reason: "global vars apps/global_vars.yml changed"},
+		{name: "global vars from the environment", changed: "env/global_vars.yml", globalVars: "env/global_vars.yml", reason: "global vars env/global_vars.yml changed"},
+		{name: "valueFrom file", changed: "apps/shared/token.txt", reason: "var file apps/shared/token.txt changed"},
+		{name: "helm values file", changed: "shared/values.yml", reason: "helm values file shared/values.yml changed"},
+		{name: "unrelated file", changed: "other/metadata.yml"},
+		{name: "global vars not set in the environment", changed: "env/global_vars.yml"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reasons, err := changeReasons(fs, "apps/web", tt.globalVars, map[string]bool{tt.changed: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.reason == "" {
+				if len(reasons) != 0 {
+					t.Errorf("expected the folder not to be affected, got %v", reasons)
+				}
+
+				return
+			}
+
+			if len(reasons) != 1 || reasons[0] != tt.reason {
+				t.Errorf("expected reason %q, got %v", tt.reason, reasons)
+			}
+		})
+	}
+}
+
+func TestGlobalVarsEnvFileIsRelativeToTheRepo(t *testing.T) {
+	defer setEnv(map[string]string{"KUBE_DEPLOY_GLOBAL_VARS": "./env/../env/global_vars.yml"})()
+
+	// the deploy reads the file from the root of the repo, not the working directory
+	if f := globalVarsEnvFile(); f != "env/global_vars.yml" {
+		t.Errorf("expected env/global_vars.yml, got %s", f)
+	}
+}
@@ -0,0 +1,337 @@
+package deploy
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/spf13/afero"
+	v1 "k8s.io/api/core/v1"
+)
+
+// configChecksumAnnotation is set on the pod template of workloads so they roll out when the secrets or config maps they use change
+const configChecksumAnnotation = "kube-deploy.io/config-checksum"
+
+var yamlDocumentSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+// workloadKinds have a pod template at spec.template
+var workloadKinds = map[string]bool{
+	"Deployment":  true,
+	"StatefulSet": true,
+	"DaemonSet":   true,
+}
+
+func configKey(kind string, namespace string, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+func hashConfig(v interface{}) string {
+	// json sorts map keys so the hash only changes when the content does
+	b, _ := json.Marshal(v)
+	return fmt.Sprintf("%x", sha256.Sum256(b))
+}
+
+// recordSecretHash remembers the content hash of a secret that is applied, workloads using it are annotated with it
+func (c context) recordSecretHash(secret v1.Secret) {
+	if c.configHashes == nil {
+		return
+	}
+
+	c.configHashes[configKey("Secret", secret.Namespace, secret.Name)] = hashConfig(map[string]interface{}{
+		"type":       secret.Type,
+		"data":       secret.Data,
+		"stringData": secret.StringData,
+	})
+}
+
+type manifestFile struct {
+	path      string
+	json      bool
+	documents []map[string]interface{}
+}
+
+func readManifestFiles(fs afero.Fs, folder string) ([]manifestFile, error) {
+	files, err := listAllFilesInFolder(fs, folder)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := []manifestFile{}
+
+	for _, file := range files {
+		ext := filepath.Ext(file)
+		if ext != ".yaml" && ext != ".yml" && ext != ".json" {
+			continue
+		}
+
+		content, err := afero.ReadFile(fs, file)
+		if err != nil {
+			return nil, err
+		}
+
+		m := manifestFile{path: file, json: ext == ".json"}
+
+		for _, doc := range yamlDocumentSeparator.Split(string(content), -1) {
+			document := map[string]interface{}{}
+
+			// files that aren't manifests, like kustomize patches or helm values, are left alone
+			if err := yaml.Unmarshal([]byte(doc), &document); err != nil {
+				m.documents = nil
+				break
+			}
+
+			m.documents = append(m.documents, document)
+		}
+
+		manifests = append(manifests, m)
+	}
+
+	return manifests, nil
+}
+
+// stampConfigChecksums annotates the pod templates of the workloads in the folder with a checksum of the secrets and
+// config maps they reference through env, envFrom or volumes. only secrets and config maps applied by this deploy are included.
+// helm folders are rendered by helm so they aren't stamped, see namespaceConfigChecksum
+func stampConfigChecksums(c context, namespace string, folder DeployFolder) error {
+	files, err := readManifestFiles(c.fs, folder.Path)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		for _, doc := range f.documents {
+			kind, _ := doc["kind"].(string)
+			if kind != "ConfigMap" && kind != "Secret" {
+				continue
+			}
+
+			metadata, _ := doc["metadata"].(map[string]interface{})
+			name, _ := metadata["name"].(string)
+			if name == "" {
+				continue
+			}
+
+			ns, _ := metadata["namespace"].(string)
+			if ns == "" {
+				ns = namespace
+			}
+
+			content := map[string]interface{}{
+				"type":       doc["type"],
+				"data":       doc["data"],
+				"stringData": doc["stringData"],
+				"binaryData": doc["binaryData"],
+			}
+
+			c.configHashes[configKey(kind, ns, name)] = hashConfig(content)
+		}
+	}
+
+	for _, f := range files {
+		changed := false
+
+		for _, doc := range f.documents {
+			stamped, err := stampWorkload(c, namespace, doc)
+			if err != nil {
+				return fmt.Errorf("failed to add config checksum in %s: %w", f.path, err)
+			}
+
+			changed = changed || stamped
+		}
+
+		if !changed {
+			continue
+		}
+
+		err = writeManifestFile(c.fs, f)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stampWorkload sets the checksum annotation on a workload's pod template, it returns false when nothing was changed
+func stampWorkload(c context, namespace string, doc map[string]interface{}) (bool, error) {
+	kind, _ := doc["kind"].(string)
+	if !workloadKinds[kind] {
+		return false, nil
+	}
+
+	metadata, _ := doc["metadata"].(map[string]interface{})
+	spec, _ := doc["spec"].(map[string]interface{})
+	template, _ := spec["template"].(map[string]interface{})
+
+	if template == nil {
+		return false, nil
+	}
+
+	ns, _ := metadata["namespace"].(string)
+	if ns == "" {
+		ns = namespace
+	}
+
+	b, err := json.Marshal(template)
+	if err != nil {
+		return false, err
+	}
+
+	podTemplate := v1.PodTemplateSpec{}
+
+	err = json.Unmarshal(b, &podTemplate)
+	if err != nil {
+		return false, err
+	}
+
+	hashes := []string{}
+
+	for _, key := range referencedConfigs(podTemplate.Spec, ns) {
+		if hash, ok := c.configHashes[key]; ok {
+			hashes = append(hashes, key+"="+hash)
+		}
+	}
+
+	if len(hashes) == 0 {
+		return false, nil
+	}
+
+	templateMetadata, _ := template["metadata"].(map[string]interface{})
+	if templateMetadata == nil {
+		templateMetadata = make(map[string]interface{})
+		template["metadata"] = templateMetadata
+	}
+
+	annotations, _ := templateMetadata["annotations"].(map[string]interface{})
+	if annotations == nil {
+		annotations = make(map[string]interface{})
+		templateMetadata["annotations"] = annotations
+	}
+
+	annotations[configChecksumAnnotation] = hashConfig(hashes)
+
+	return true, nil
+}
+
+// namespaceConfigChecksum is a checksum of every secret and config map applied by this deploy in the namespace so far.
+// helm charts get it through HelmChart.ConfigChecksumValuesKey since their workloads can't be stamped before rendering
+func namespaceConfigChecksum(c context, namespace string) string {
+	hashes := []string{}
+
+	for key, hash := range c.configHashes {
+		parts := strings.SplitN(key, "/", 3)
+		if len(parts) == 3 && parts[1] == namespace {
+			hashes = append(hashes, key+"="+hash)
+		}
+	}
+
+	sort.Strings(hashes)
+
+	return hashConfig(hashes)
+}
+
+// referencedConfigs lists the config keys of the secrets and config maps a pod spec uses
+func referencedConfigs(spec v1.PodSpec, namespace string) []string {
+	refs := map[string]bool{}
+
+	add := func(kind string, name string) {
+		if name != "" {
+			refs[configKey(kind, namespace, name)] = true
+		}
+	}
+
+	containers := append(append([]v1.Container{}, spec.InitContainers...), spec.Containers...)
+
+	for _, container := range containers {
+		for _, env := range container.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+
+			if ref := env.ValueFrom.SecretKeyRef; ref != nil {
+				add("Secret", ref.Name)
+			}
+
+			if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil {
+				add("ConfigMap", ref.Name)
+			}
+		}
+
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.SecretRef != nil {
+				add("Secret", envFrom.SecretRef.Name)
+			}
+
+			if envFrom.ConfigMapRef != nil {
+				add("ConfigMap", envFrom.ConfigMapRef.Name)
+			}
+		}
+	}
+
+	for _, volume := range spec.Volumes {
+		if volume.Secret != nil {
+			add("Secret", volume.Secret.SecretName)
+		}
+
+		if volume.ConfigMap != nil {
+			add("ConfigMap", volume.ConfigMap.Name)
+		}
+
+		if volume.Projected == nil {
+			continue
+		}
+
+		for _, source := range volume.Projected.Sources {
+			if source.Secret != nil {
+				add("Secret", source.Secret.Name)
+			}
+
+			if source.ConfigMap != nil {
+				add("ConfigMap", source.ConfigMap.Name)
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(refs))
+	for k := range refs {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func writeManifestFile(fs afero.Fs, f manifestFile) error {
+	docs := make([]string, 0, len(f.documents))
+
+	for _, doc := range f.documents {
+		// empty documents, like a leading ---, are dropped
+		if len(doc) == 0 {
+			continue
+		}
+
+		var b []byte
+
+		var err error
+
+		if f.json {
+			b, err = json.MarshalIndent(doc, "", "  ")
+		} else {
+			b, err = yaml.Marshal(doc)
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", f.path, err)
+		}
+
+		docs = append(docs, strings.TrimSuffix(string(b), "\n"))
+	}
+
+	return afero.WriteFile(fs, f.path, []byte(strings.Join(docs, "\n---\n")+"\n"), 0644)
+}
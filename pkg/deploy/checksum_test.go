@@ -0,0 +1,25 @@
+package deploy
+
+import "testing"
+
+func TestNamespaceConfigChecksum(t *testing.T) {
+	c := context{configHashes: map[string]string{
+		configKey("Secret", "web", "db"):       "a",
+		configKey("ConfigMap", "web", "app"):   "b",
+		configKey("Secret", "worker", "queue"): "c",
+	}}
+
+	checksum := namespaceConfigChecksum(c, "web")
+
+	c.configHashes[configKey("Secret", "worker", "queue")] = "changed"
+
+	if namespaceConfigChecksum(c, "web") != checksum {
+		t.Error("expected configs in other namespaces not to change the checksum")
+	}
+
+	c.configHashes[configKey("Secret", "web", "db")] = "changed"
+
+	if namespaceConfigChecksum(c, "web") == checksum {
+		t.Error("expected a changed secret in the namespace to change the checksum")
+	}
+}
@@ -0,0 +1,267 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bcaldwell/kube-deploy/pkg/lib/kubeapi"
+	"github.com/bcaldwell/kube-deploy/pkg/lib/logger"
+	"github.com/spf13/afero"
+)
+
+// errClusterHalted is returned for clusters that were skipped because another cluster failed with the halt policy
+var errClusterHalted = errors.New("halted after another cluster failed")
+
+// haltFlag is set when a cluster fails with the halt policy, clusters that haven't started deploying are skipped after it is set
+type haltFlag struct {
+	halted int32
+}
+
+func (h *haltFlag) set() {
+	atomic.StoreInt32(&h.halted, 1)
+}
+
+func (h *haltFlag) isSet() bool {
+	return atomic.LoadInt32(&h.halted) == 1
+}
+
+type clusterResult struct {
+	cluster  Cluster
+	err      error
+	skipped  bool
+	duration time.Duration
+}
+
+// clusters returns the clusters to deploy to, falling back to a single cluster from the deploy's own kube config settings
+func (d *Deploy) clusters() []Cluster {
+	if len(d.Clusters) == 0 {
+		return []Cluster{{
+			Name:            "default",
+			KubeconfigEnv:   d.KubeconfigEnv,
+			KubeconfigPath:  d.KubeconfigPath,
+			Context:         d.KubeContext,
+			ExpectedCluster: d.ExpectedCluster,
+			Bastion:         d.Bastion,
+		}}
+	}
+
+	clusters := make([]Cluster, len(d.Clusters))
+
+	for i, c := range d.Clusters {
+		if c.Context == "" {
+			c.Context = d.KubeContext
+		}
+
+		if c.Bastion == nil {
+			c.Bastion = d.Bastion
+		}
+
+		clusters[i] = c
+	}
+
+	return clusters
+}
+
+func (d *Deploy) parallelClusters() bool {
+	return d.ParallelClusters != nil && *d.ParallelClusters
+}
+
+func (d *Deploy) validateClusters() error {
+	names := make(map[string]bool)
+	bastions := 0
+
+	for i, c := range d.Clusters {
+		if c.Name == "" {
+			return fmt.Errorf("cluster[%d] must set a name", i)
+		}
+
+		if names[c.Name] {
+			return fmt.Errorf("cluster %s is defined more than once", c.Name)
+		}
+
+		names[c.Name] = true
+	}
+
+	// clusters inherit the deploy bastion
+	for _, c := range d.clusters() {
+		if c.Bastion != nil && c.Bastion.Enabled {
+			bastions++
+		}
+	}
+
+	// every bastion forwards the api server to the same local port
+	if d.parallelClusters() && bastions > 1 {
+		return fmt.Errorf("parallelClusters can not be used with more than one bastion")
+	}
+
+	return nil
+}
+
+// deployClusters deploys the rendered config to every cluster and logs a summary
+func (d *Deploy) deployClusters() error {
+	err := d.validateClusters()
+	if err != nil {
+		return err
+	}
+
+	clusters := d.clusters()
+	results := make([]clusterResult, len(clusters))
+	halt := &haltFlag{}
+
+	if d.parallelClusters() {
+		var wg sync.WaitGroup
+
+		for i, c := range clusters {
+			wg.Add(1)
+
+			go func(i int, c Cluster) {
+				defer wg.Done()
+				results[i] = d.deployCluster(i, c, halt)
+			}(i, c)
+		}
+
+		wg.Wait()
+	} else {
+		for i, c := range clusters {
+			results[i] = d.deployCluster(i, c, halt)
+		}
+	}
+
+	return summarizeClusterResults(results, d.ConfigCommit)
+}
+
+// deployCluster deploys to a cluster unless the deploy was halted, it halts the deploy when it fails with the halt policy.
+// parallel clusters that already started deploying when the deploy is halted are finished
+func (d *Deploy) deployCluster(i int, cluster Cluster, halt *haltFlag) clusterResult {
+	if halt.isSet() {
+		return clusterResult{cluster: cluster, skipped: true}
+	}
+
+	start := time.Now()
+
+	logger.Log("deploying to cluster %s", cluster.Name)
+
+	err := d.deployToCluster(i, cluster, halt)
+	if errors.Is(err, errClusterHalted) {
+		logger.Log("skipping cluster %s: %s", cluster.Name, err)
+		return clusterResult{cluster: cluster, skipped: true}
+	}
+
+	if err != nil {
+		logger.Log("failed to deploy to cluster %s: %s", cluster.Name, err)
+
+		if d.FailurePolicy == FailurePolicyHalt {
+			halt.set()
+		}
+	}
+
+	return clusterResult{
+		cluster:  cluster,
+		err:      err,
+		duration: time.Since(start).Round(time.Millisecond),
+	}
+}
+
+func (d *Deploy) deployToCluster(i int, cluster Cluster, halt *haltFlag) error {
+	kubeConfig, err := cluster.getKubeConfig(len(d.Clusters) == 0)
+	if err != nil {
+		return err
+	}
+
+	// remove the kubeconfig if it is a temp file
+	if strings.HasPrefix(kubeConfig, path.Join(os.TempDir(), tempKubeconfigPrefix)) {
+		defer os.Remove(kubeConfig)
+	}
+
+	if cluster.Bastion != nil {
+		sshTun := cluster.Bastion.setupPortForward(cluster.Bastion.Host, 6443, cluster.Bastion.RemotePortforwardHost, 6443)
+		if sshTun != nil {
+			defer sshTun.Stop()
+		}
+	}
+
+	// every cluster works on its own copy of the config because secret files are removed from it while deploying
+	clusterDeploy := *d
+	clusterDir := fmt.Sprintf("cluster-%d", i)
+	clusterDeploy.kube = kubeapi.Client{
+		Kubeconfig: kubeConfig,
+		Context:    cluster.Context,
+		Env:        varsToEnv(cluster.Vars),
+		DryRun:     d.DryRun,
+	}
+
+	err = verifyCluster(clusterDeploy.kube, cluster)
+	if err != nil {
+		return err
+	}
+
+	if len(cluster.Vars) != 0 {
+		// cluster vars can use valueFrom and reference the deploy vars
+		clusterDeploy.Vars, err = resolveVarsOver(d.srcFs, d.localDir, d.ConfigFolder, d.Vars, cluster.Vars)
+		if err != nil {
+			return fmt.Errorf("failed to resolve vars for cluster %s: %w", cluster.Name, err)
+		}
+
+		logger.Log("rendering config for cluster %s with cluster vars", cluster.Name)
+
+		clusterDeploy.fs, err = clusterDeploy.render(clusterDir)
+	} else {
+		clusterDeploy.fs = afero.NewBasePathFs(afero.NewOsFs(), path.Join(d.rootDir, clusterDir))
+
+		err = copyAndProcessFolder(d.fs, d.ConfigFolder, clusterDeploy.fs, "/", func(_, src string) (string, error) {
+			return src, nil
+		})
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to prepare config for cluster %s: %w", cluster.Name, err)
+	}
+
+	clusterDeploy.rootDir = path.Join(d.rootDir, clusterDir)
+
+	clusterDeploy.convertSecret, err = clusterDeploy.secretConverter()
+	if err != nil {
+		return fmt.Errorf("failed to set up secret output for cluster %s: %w", cluster.Name, err)
+	}
+
+	// parallel clusters check again once the config is ready since another cluster may have failed while it was prepared
+	if halt.isSet() {
+		return errClusterHalted
+	}
+
+	return clusterDeploy.runDeploy()
+}
+
+func summarizeClusterResults(results []clusterResult, commit string) error {
+	failed := []string{}
+
+	if commit != "" {
+		logger.Log("cluster summary for config commit %s:", commit)
+	} else {
+		logger.Log("cluster summary:")
+	}
+
+	for _, r := range results {
+		switch {
+		case r.skipped:
+			logger.Log("  %s: skipped", r.cluster.Name)
+		case r.err != nil:
+			logger.Log("  %s: failed after %s: %s", r.cluster.Name, r.duration, r.err)
+			failed = append(failed, r.cluster.Name)
+		default:
+			logger.Log("  %s: succeeded in %s", r.cluster.Name, r.duration)
+		}
+	}
+
+	if len(failed) != 0 {
+		return fmt.Errorf("failed to deploy to clusters: %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
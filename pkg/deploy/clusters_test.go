@@ -0,0 +1,62 @@
+package deploy
+
+import (
+	"testing"
+)
+
+func TestClustersInheritDeployConfig(t *testing.T) {
+	bastion := &Bastion{Enabled: true, Host: "bastion"}
+	own := &Bastion{Enabled: true, Host: "own"}
+
+	d := &Deploy{
+		KubeContext: "default-ctx",
+		Bastion:     bastion,
+		Clusters: []Cluster{
+			{Name: "a"},
+			{Name: "b", Context: "b-ctx", Bastion: own},
+		},
+	}
+
+	clusters := d.clusters()
+
+	if clusters[0].Context != "default-ctx" || clusters[0].Bastion != bastion {
+		t.Errorf("expected cluster a to inherit the context and bastion, got %s and %v", clusters[0].Context, clusters[0].Bastion)
+	}
+
+	if clusters[1].Context != "b-ctx" || clusters[1].Bastion != own {
+		t.Errorf("expected cluster b to keep its context and bastion, got %s and %v", clusters[1].Context, clusters[1].Bastion)
+	}
+}
+
+func TestValidateClustersParallelBastions(t *testing.T) {
+	parallel := true
+	sequential := false
+
+	d := &Deploy{
+		Bastion:  &Bastion{Enabled: true},
+		Clusters: []Cluster{{Name: "a"}, {Name: "b"}},
+	}
+
+	for _, tt := range []struct {
+		name     string
+		parallel *bool
+		valid    bool
+	}{
+		{name: "unset", parallel: nil, valid: true},
+		{name: "sequential", parallel: &sequential, valid: true},
+		{name: "parallel", parallel: &parallel, valid: false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			d.ParallelClusters = tt.parallel
+
+			err := d.validateClusters()
+			if tt.valid && err != nil {
+				t.Errorf("expected the clusters to be valid, got %s", err)
+			}
+
+			if !tt.valid && err == nil {
+				t.Error("expected inherited bastions to be refused with parallel clusters")
+			}
+		})
+	}
+}
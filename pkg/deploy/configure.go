@@ -1,12 +1,16 @@
 package deploy
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"path"
+	"reflect"
+	"strings"
 
 	"github.com/bcaldwell/kube-deploy/pkg/lib/logger"
+	"github.com/bcaldwell/kube-deploy/pkg/lib/vaultsecret"
 	"github.com/ghodss/yaml"
 	"github.com/imdario/mergo"
 	"github.com/spf13/afero"
@@ -17,19 +21,35 @@ var fileNotFoundErr = errors.New("file not found")
 type Metadata struct {
 	MetadataConfig
 	Targets []Target `json:"targets"`
+	// metadata fragments relative to this file, they are merged in order before this file is merged over them
+	Include []string `json:"include"`
 }
 
 type MetadataConfig struct {
 	Helm        *HelmChart `json:"helm"`
 	Targets     []Target   `json:"targets"`
-	Vars        map[string]string
+	Vars        Vars
 	Namespace   string
 	ReleaseName string
 	Folders     []DeployFolder
+
+	CreateNamespace *bool            `json:"createNamespace"`
+	NamespaceConfig *NamespaceConfig `json:"namespaceConfig"`
+
+	Clusters         []Cluster
+	ParallelClusters *bool
+	FailurePolicy    FailurePolicy
+	KubeContext      string
+	ExpectedCluster  *ClusterIdentity `json:"expectedCluster"`
+	Secrets          []SecretDeclaration
+	Vault            *vaultsecret.Config `json:"vault"`
+	SecretOutput     *SecretOutput       `json:"secretOutput"`
 }
 
 type Target struct {
-	Name         string `json:"name"`
+	Name string `json:"name"`
+	// targets to inherit from, they are merged in order before this target is merged over them
+	Extends      []string
 	MergeFolders []MergeFolder
 	MetadataConfig
 }
@@ -39,47 +59,57 @@ type MergeFolder struct {
 	DeployFolder
 }
 
+const globalVarsFile = "global_vars.yml"
+
 type GlobalVars struct {
-	GlobalVars map[string]string `json:"global_vars"`
+	GlobalVars Vars `json:"global_vars"`
+	// vars only used when deploying the target with the matching name or a target extending it
+	Targets map[string]Vars `json:"targets"`
 }
 
 func (d *Deploy) ConfigureFolderFromMetadata(folder string, targetName string) error {
 	metadataFile := path.Join(folder, "metadata.yml")
-	m := Metadata{}
 
-	err := readAndUnmarshal(d.srcFs, &m, os.Getenv("KUBE_DEPLOY_METADATA_FILE"), metadataFile)
-	if errors.Is(err, fileNotFoundErr) {
+	if f := os.Getenv("KUBE_DEPLOY_METADATA_FILE"); f != "" {
+		if _, err := d.srcFs.Stat(f); err == nil {
+			metadataFile = f
+		}
+	}
+
+	if _, err := d.srcFs.Stat(metadataFile); os.IsNotExist(err) {
 		logger.Log("skipping configuring from metadata.yml, %s does not exist", metadataFile)
+		d.Vars = mergeVars(d.Vars, d.builtinVars())
+
 		return nil
 	}
 
+	m, metadataLayers, err := loadMetadata(d.srcFs, metadataFile, nil)
 	if err != nil {
 		return err
 	}
 
-	globalVars, err := d.getGlobalVars(folder)
+	target, chain, err := getTargetConfig(targetName, m.Targets)
 	if err != nil {
 		return err
 	}
 
-	if m.Vars == nil {
-		m.Vars = make(map[string]string)
+	globalVars, err := d.getGlobalVars(folder, chain)
+	if err != nil {
+		return err
 	}
 
-	for k, v := range globalVars.GlobalVars {
-		// skip existing ones
-		if _, ok := m.Vars[k]; ok {
-			continue
-		}
+	vars := mergeVars(globalVars, m.Vars)
 
-		m.Vars[k] = v
-	}
+	d.layers = append(d.layers, metadataLayers...)
 
 	metadataDeploy := convertMetadataToDeploy(d.srcFs, folder, m.MetadataConfig, true)
 
-	target, err := getTargetConfig(targetName, m.Targets)
-	if err != nil {
-		return err
+	for _, t := range chain {
+		d.layers = append(d.layers, configLayer{
+			Name:  fmt.Sprintf("target %s", t.Name),
+			Value: Target{MergeFolders: t.MergeFolders, MetadataConfig: t.MetadataConfig},
+			raw:   rawTarget(metadataLayers, t.Name),
+		})
 	}
 
 	mergoOpts := []func(*mergo.Config){mergo.WithOverrideEmptySlice}
@@ -87,6 +117,8 @@ func (d *Deploy) ConfigureFolderFromMetadata(folder string, targetName string) e
 	if target != nil {
 		logger.Log("found target overrides for %s", targetName)
 
+		vars = mergeVars(vars, target.Vars)
+
 		targetDeploy := convertMetadataToDeploy(d.srcFs, folder, target.MetadataConfig, false)
 
 		// only use merge logic if folders is not set in the target
@@ -100,7 +132,7 @@ func (d *Deploy) ConfigureFolderFromMetadata(folder string, targetName string) e
 		}
 
 		// this a bit strange and flipped so that targetDeploy is the more important one
-		err = mergo.Merge(targetDeploy, metadataDeploy, mergoOpts...)
+		err = mergeConfig(targetDeploy, metadataDeploy, mergoOpts...)
 		if err != nil {
 			return fmt.Errorf("failed to merge target config with metadata config: %w", err)
 		}
@@ -108,15 +140,101 @@ func (d *Deploy) ConfigureFolderFromMetadata(folder string, targetName string) e
 		metadataDeploy = targetDeploy
 	}
 
-	return mergo.Merge(d, metadataDeploy, mergoOpts...)
+	err = mergeConfig(d, metadataDeploy, mergoOpts...)
+	if err != nil {
+		return err
+	}
+
+	// vars are deep merged separately because mergo only merges them key by key, vars set on the deploy win
+	d.Vars, err = resolveVars(d.srcFs, d.localDir, folder, mergeVars(mergeVars(vars, d.Vars), d.builtinVars()))
+
+	return err
+}
+
+// builtinVars are set by kube-deploy and can't be overridden, other vars can reference them
+func (d *Deploy) builtinVars() Vars {
+	vars := Vars{}
+
+	if d.ConfigCommit != "" {
+		vars[configCommitVar] = d.ConfigCommit
+	}
+
+	if len(vars) != 0 {
+		d.addLayer("built-in", map[string]interface{}{"Vars": vars})
+	}
+
+	return vars
+}
+
+// getGlobalVars merges every global_vars.yml from the root of the fs down to folder, the nearest file wins.
+// within a file the sections for the target chain are merged over the global_vars section.
+// the file set in KUBE_DEPLOY_GLOBAL_VARS is merged last
+func (d *Deploy) getGlobalVars(folder string, chain []Target) (Vars, error) {
+	files := []string{}
+	for _, dir := range folderHierarchy(folder) {
+		files = append(files, path.Join(dir, globalVarsFile))
+	}
+
+	if f := globalVarsEnvFile(); f != "" {
+		files = append(files, f)
+	}
+
+	vars := Vars{}
+
+	for _, f := range files {
+		globalVars := GlobalVars{}
+
+		err := readAndUnmarshal(d.srcFs, &globalVars, f)
+		if errors.Is(err, fileNotFoundErr) {
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to load global vars from %s: %w", f, err)
+		}
+
+		vars = mergeVars(vars, globalVars.GlobalVars)
+		d.addLayer(f, MetadataConfig{Vars: globalVars.GlobalVars})
+
+		for _, t := range chain {
+			targetVars, ok := globalVars.Targets[t.Name]
+			if !ok {
+				continue
+			}
+
+			vars = mergeVars(vars, targetVars)
+			d.addLayer(fmt.Sprintf("%s target %s", f, t.Name), MetadataConfig{Vars: targetVars})
+		}
+	}
+
+	return vars, nil
+}
+
+// globalVarsEnvFile returns the file set in KUBE_DEPLOY_GLOBAL_VARS, it is relative to the root of the config repo
+func globalVarsEnvFile() string {
+	f := os.Getenv("KUBE_DEPLOY_GLOBAL_VARS")
+	if f == "" {
+		return ""
+	}
+
+	return path.Clean(f)
 }
 
-func (d *Deploy) getGlobalVars(folder string) (GlobalVars, error) {
-	globalEnv := GlobalVars{}
+// folderHierarchy returns every folder from the root down to and including folder
+func folderHierarchy(folder string) []string {
+	folder = path.Clean(strings.TrimPrefix(path.Clean(folder), "/"))
+	folders := []string{"."}
 
-	err := readAndUnmarshal(d.srcFs, &globalEnv, os.Getenv("KUBE_DEPLOY_GLOBAL_VARS"), "global_vars.yml", path.Join(folder, "global_vars.yml"))
+	if folder == "." {
+		return folders
+	}
 
-	return globalEnv, err
+	parts := strings.Split(folder, "/")
+	for i := range parts {
+		folders = append(folders, path.Join(parts[:i+1]...))
+	}
+
+	return folders
 }
 
 func readAndUnmarshal(fs afero.Fs, o interface{}, paths ...string) error {
@@ -143,26 +261,163 @@ func readAndUnmarshal(fs afero.Fs, o interface{}, paths ...string) error {
 	return fileNotFoundErr
 }
 
-func getTargetConfig(targetName string, targets []Target) (*Target, error) {
+// getTargetConfig returns the target merged with everything it extends along with the chain of targets in merge order
+func getTargetConfig(targetName string, targets []Target) (*Target, []Target, error) {
 	if targetName == "" {
-		return nil, nil
+		return nil, nil, nil
+	}
+
+	chain, err := getTargetChain(targetName, targets, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	merged := Target{}
+	seen := make(map[string]bool)
+	dedupedChain := []Target{}
+
+	for _, t := range chain {
+		// a target extended through several paths is only merged the first time it is seen
+		if seen[t.Name] {
+			continue
+		}
+
+		seen[t.Name] = true
+		dedupedChain = append(dedupedChain, t)
+
+		merged, err = mergeTargets(merged, t)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to merge target %s: %w", t.Name, err)
+		}
+	}
+
+	merged.Name = targetName
+	merged.Extends = nil
+
+	return &merged, dedupedChain, nil
+}
+
+func getTargetChain(targetName string, targets []Target, stack []string) ([]Target, error) {
+	for _, s := range stack {
+		if s == targetName {
+			return nil, fmt.Errorf("target extends cycle detected: %s -> %s", strings.Join(stack, " -> "), targetName)
+		}
+	}
+
+	var target *Target
+
+	for i := range targets {
+		if targets[i].Name == targetName {
+			target = &targets[i]
+			break
+		}
+	}
+
+	if target == nil {
+		if len(stack) > 0 {
+			return nil, fmt.Errorf("unable to find target %s extended by %s in target list", targetName, stack[len(stack)-1])
+		}
+
+		return nil, fmt.Errorf("unable to find target %s in target list", targetName)
+	}
+
+	stack = append(stack, targetName)
+	chain := []Target{}
+
+	for _, parent := range target.Extends {
+		parentChain, err := getTargetChain(parent, targets, stack)
+		if err != nil {
+			return nil, err
+		}
+
+		chain = append(chain, parentChain...)
+	}
+
+	return append(chain, *target), nil
+}
+
+// mergeTargets merges override over base, values set in override win
+func mergeTargets(base Target, override Target) (Target, error) {
+	merged := Target{}
+
+	err := cloneConfig(override, &merged)
+	if err != nil {
+		return merged, err
+	}
+
+	vars := mergeVars(base.Vars, override.Vars)
+	base.Vars = nil
+	merged.Vars = nil
+
+	err = mergeConfig(&merged, base)
+	merged.Vars = vars
+
+	return merged, err
+}
+
+// mergeConfig merges src into dst the same as mergo.Merge except *bool fields that are set in dst are kept.
+// mergo merges the values pointers point at, which turns a false that was set explicitly into true
+func mergeConfig(dst interface{}, src interface{}, opts ...func(*mergo.Config)) error {
+	set := make(map[*bool]bool)
+	collectSetBools(reflect.ValueOf(dst), set)
+
+	err := mergo.Merge(dst, src, opts...)
+
+	for b, v := range set {
+		*b = v
 	}
 
-	for _, t := range targets {
-		if t.Name == targetName {
-			return &t, nil
+	return err
+}
+
+func collectSetBools(v reflect.Value, set map[*bool]bool) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+
+		if b, ok := v.Interface().(*bool); ok {
+			set[b] = *b
+			return
+		}
+
+		collectSetBools(v.Elem(), set)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			// unexported fields aren't merged
+			if v.Type().Field(i).PkgPath == "" {
+				collectSetBools(v.Field(i), set)
+			}
 		}
 	}
+}
+
+// cloneConfig deep copies src into dest through json so merging doesn't modify pointers shared with src
+func cloneConfig(src interface{}, dest interface{}) error {
+	b, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
 
-	return nil, fmt.Errorf("unable to find target %s in target list", targetName)
+	return json.Unmarshal(b, dest)
 }
 
 func convertMetadataToDeploy(fs afero.Fs, folder string, m MetadataConfig, defaultFolders bool) *Deploy {
 	return &Deploy{
-		ConfigFolder:  folder,
-		Vars:          m.Vars,
-		Namespace:     m.Namespace,
-		DeployFolders: configureDeployFolders(fs, folder, m.Folders, m.Helm, defaultFolders),
+		ConfigFolder:     folder,
+		Namespace:        m.Namespace,
+		CreateNamespace:  m.CreateNamespace,
+		NamespaceConfig:  m.NamespaceConfig,
+		Clusters:         m.Clusters,
+		ParallelClusters: m.ParallelClusters,
+		FailurePolicy:    m.FailurePolicy,
+		KubeContext:      m.KubeContext,
+		ExpectedCluster:  m.ExpectedCluster,
+		Secrets:          m.Secrets,
+		Vault:            m.Vault,
+		SecretOutput:     m.SecretOutput,
+		DeployFolders:    configureDeployFolders(fs, folder, m.Folders, m.Helm, defaultFolders),
 	}
 }
 
@@ -0,0 +1,123 @@
+package deploy
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// setEnv sets env variables, empty values unset them, and returns a func restoring the previous values
+func setEnv(env map[string]string) func() {
+	previous := make(map[string]*string)
+
+	for k, v := range env {
+		if old, ok := os.LookupEnv(k); ok {
+			previous[k] = &old
+		} else {
+			previous[k] = nil
+		}
+
+		if v == "" {
+			os.Unsetenv(k)
+		} else {
+			os.Setenv(k, v)
+		}
+	}
+
+	return func() {
+		for k, v := range previous {
+			if v == nil {
+				os.Unsetenv(k)
+			} else {
+				os.Setenv(k, *v)
+			}
+		}
+	}
+}
+
+func TestGetGlobalVars(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	files := map[string]string{
+		"global_vars.yml": `global_vars: {ROOT: root, LEVEL: root, DB: {host: root-db, port: 5432}}
+targets:
+  base: {TARGET: root-base}
+`,
+		"apps/global_vars.yml": `global_vars: {LEVEL: apps, DB: {host: apps-db}}
+targets:
+  base: {TARGET: apps-base, BASE_ONLY: apps}
+  prod: {TARGET: apps-prod}
+`,
+		"apps/web/global_vars.yml": "global_vars: {LEVEL: web}\n",
+		"env/global_vars.yml":      "global_vars: {LEVEL: env, ENV: env}\ntargets:\n  prod: {TARGET: env-prod}\n",
+	}
+
+	for name, content := range files {
+		if err := afero.WriteFile(fs, name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// prod extends base so base is merged first
+	chain := []Target{{Name: "base"}, {Name: "prod"}}
+
+	tests := []struct {
+		name       string
+		globalVars string
+		chain      []Target
+		expected   Vars
+	}{
+		{
+			name: "nearest file wins",
+			expected: Vars{
+				"ROOT":  "root",
+				"LEVEL": "web",
+				"DB":    map[string]interface{}{"host": "apps-db", "port": float64(5432)},
+			},
+		},
+		{
+			name:  "target sections override each file",
+			chain: chain,
+			expected: Vars{
+				"ROOT":      "root",
+				"LEVEL":     "web",
+				"DB":        map[string]interface{}{"host": "apps-db", "port": float64(5432)},
+				"TARGET":    "apps-prod",
+				"BASE_ONLY": "apps",
+			},
+		},
+		{
+			name:       "KUBE_DEPLOY_GLOBAL_VARS is merged last",
+			globalVars: "env/global_vars.yml",
+			chain:      chain,
+			expected: Vars{
+				"ROOT":      "root",
+				"LEVEL":     "env",
+				"ENV":       "env",
+				"DB":        map[string]interface{}{"host": "apps-db", "port": float64(5432)},
+				"TARGET":    "env-prod",
+				"BASE_ONLY": "apps",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer setEnv(map[string]string{"KUBE_DEPLOY_GLOBAL_VARS": tt.globalVars})()
+
+			d := &Deploy{}
+			d.srcFs = fs
+
+			vars, err := d.getGlobalVars("apps/web", tt.chain)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(vars, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, vars)
+			}
+		})
+	}
+}
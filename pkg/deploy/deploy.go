@@ -4,20 +4,14 @@ import (
 	"bytes"
 	"crypto/md5"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io/ioutil"
-	"os"
 	"os/exec"
 	"path"
-	"path/filepath"
+	"strings"
 
-	"github.com/bcaldwell/kube-deploy/pkg/lib/ejsonsecret"
-	"github.com/bcaldwell/kube-deploy/pkg/lib/kubeapi"
 	"github.com/bcaldwell/kube-deploy/pkg/lib/logger"
+	"github.com/ghodss/yaml"
 	"github.com/spf13/afero"
-	v1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type helmRepoListItem struct {
@@ -26,37 +20,61 @@ type helmRepoListItem struct {
 }
 
 func (d *Deploy) runDeploy() error {
-	err := createNamespace(d.Namespace)
+	d.configHashes = make(map[string]string)
+	d.deployNamespaces = d.namespaces()
+
+	err := d.createNamespaces()
 	if err != nil {
-		return fmt.Errorf("error while creating namespace %s %w", d.Namespace, err)
+		return err
 	}
 
+	err = d.deployDeclaredSecrets()
+	if err != nil {
+		return err
+	}
+
+	modifiedNamespaces := []string{}
+
 	for _, folder := range d.DeployFolders {
 		if _, err := d.fs.Stat(folder.Path); err != nil {
 			logger.Log("folder %s not found", folder.Path)
-			return nil
+			break
 		}
 
-		logger.Log("deploying folder %s using %s as the render engine", folder.Path, folder.RenderEngine)
+		renderEngine := getRenderEngineWithDefault(d.fs, folder)
+		namespace := d.folderNamespace(folder, renderEngine)
+
+		logger.Log("deploying folder %s into %s using %s as the render engine", folder.Path, namespace, renderEngine)
+
+		var err error
 
-		switch getRenderEngineWithDefault(d.fs, folder) {
+		switch renderEngine {
 		case RenderEngineHelm:
 			// todo: move this to a valdiation function
 			if folder.HelmChart == nil {
 				return fmt.Errorf("helm chart can not be nil when helm render engine is set. Found in %v", folder)
 			}
 
-			err = releaseHelm(d.context, d.Namespace, folder.Path, *folder.HelmChart)
+			err = releaseHelm(d.context, namespace, folder.Path, *folder.HelmChart, d.Vars)
 		case RenderEngineNone:
-			err = kubectlDeployFolder(d.context, d.Namespace, folder, []string{"-R", "-f"})
+			err = kubectlDeployFolder(d.context, namespace, folder, []string{"-R", "-f"})
 		case RenderEngineKustomize:
-			err = kubectlDeployFolder(d.context, d.Namespace, folder, []string{"-k"})
+			err = kubectlDeployFolder(d.context, namespace, folder, []string{"-k"})
 		default:
 			return fmt.Errorf("%v has invald deploy type %s", folder, folder.RenderEngine)
 		}
+
+		if err != nil {
+			return fmt.Errorf("failed to deploy folder %s: %w", folder.Path, err)
+		}
+
+		modifiedNamespaces = appendUnique(modifiedNamespaces, namespace)
 	}
 
-	return err
+	// only logged on success, a failed deploy reports the folder that failed instead
+	logger.Log("modified namespaces: %s", strings.Join(modifiedNamespaces, ", "))
+
+	return nil
 }
 
 func getRenderEngineWithDefault(fs afero.Fs, folder DeployFolder) RenderEngine {
@@ -138,24 +156,13 @@ func setupHelmRepo(repo string) (helmRepoListItem, error) {
 	}, err
 }
 
-func createNamespace(namespace string) error {
-	ns := v1.Namespace{
-		TypeMeta: metav1.TypeMeta{
-			Kind:       "Namespace",
-			APIVersion: "v1",
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name: namespace,
-		},
+func kubectlDeployFolder(c context, namespace string, folder DeployFolder, applyArgs []string) error {
+	deployedSecrets, err := deployAndDeleteSecretFiles(c, namespace, folder)
+	if err != nil {
+		return err
 	}
 
-	logger.Log("creating namespace %s", namespace)
-
-	return kubeapi.ApplyResource(ns)
-}
-
-func kubectlDeployFolder(c context, namespace string, folder DeployFolder, applyArgs []string) error {
-	err := deployAndDeleteEjsonFiles(c, namespace, folder)
+	err = stampConfigChecksums(c, namespace, folder)
 	if err != nil {
 		return err
 	}
@@ -166,62 +173,35 @@ func kubectlDeployFolder(c context, namespace string, folder DeployFolder, apply
 	}
 
 	if len(files) == 0 {
-		return nil
+		return pruneSecrets(c, folder, namespace, deployedSecrets)
 	}
 
 	folderPath := path.Join(c.rootDir, folder.Path)
 
 	args := []string{"apply"}
-	args = append(args, applyArgs...)
-	args = append(args, folderPath)
-
-	// -R for recursive
-	return runCommand(exec.Command("kubectl", args...))
-}
 
-func deployAndDeleteEjsonFiles(c context, namespace string, folder DeployFolder) error {
-	fileList, err := listAllFilesInFolder(c.fs, folder.Path)
-	if err != nil {
-		return err
+	// manifests without a namespace go into the folder namespace
+	if namespace != "" {
+		args = append(args, "-n", namespace)
 	}
 
-	ejsonFiles := filterString(fileList, func(path string) bool {
-		return filepath.Ext(path) == ".ejson"
-	})
-
-	ejsonKeyPath := os.Getenv("EJSON_KEY_PATH")
-	ejsonKey := os.Getenv("EJSON_KEY")
-
-	if ejsonKeyPath != "" && ejsonKey == "" {
-		b, err := ioutil.ReadFile(ejsonKeyPath)
-		if err != nil {
-			return err
-		}
-
-		ejsonKey = string(b)
+	if c.kube.DryRun {
+		args = append(args, "--dry-run=client")
 	}
 
-	for _, file := range ejsonFiles {
-		err = ejsonsecret.DeploySecret(path.Join(c.rootDir, file), namespace, ejsonKey)
-		if errors.Is(err, ejsonsecret.InvalidEjsonSecret) {
-			continue
-		}
-
-		if err != nil {
-			return err
-		}
+	args = append(args, applyArgs...)
+	args = append(args, folderPath)
 
-		// remove ejson file because it will cause issues with kubectl
-		err = c.fs.Remove(file)
-		if err != nil {
-			return err
-		}
+	// -R for recursive
+	err = runCommand(c.kube.Kubectl(args...))
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return pruneSecrets(c, folder, namespace, deployedSecrets)
 }
 
-func releaseHelm(c context, namespace string, folder string, chart HelmChart) error {
+func releaseHelm(c context, namespace string, folder string, chart HelmChart, vars Vars) error {
 	logger.Log("Deploying helm chart %s with release %s into %s", chart.Name, chart.ReleaseName, namespace)
 
 	helmArgs := []string{"upgrade", "--wait", "--install"}
@@ -237,8 +217,9 @@ func releaseHelm(c context, namespace string, folder string, chart HelmChart) er
 
 	helmArgs = append(helmArgs, "-n", namespace, chart.ReleaseName, getHelmChartName(chart, repoItem))
 
-	valuesFiles := chart.ValuesFiles
-	for i, f := range valuesFiles {
+	// the chart is shared by every cluster so the paths are joined on a copy
+	valuesFiles := make([]string, len(chart.ValuesFiles))
+	for i, f := range chart.ValuesFiles {
 		valuesFiles[i] = path.Join(folder, f)
 	}
 
@@ -249,17 +230,53 @@ func releaseHelm(c context, namespace string, folder string, chart HelmChart) er
 		}
 	}
 
+	if chart.VarsValuesKey != "" {
+		varsFile, err := writeVarsValuesFile(c, chart, vars)
+		if err != nil {
+			return err
+		}
+
+		// vars go first so values files in the folder can override them
+		valuesFiles = append([]string{varsFile}, valuesFiles...)
+	}
+
 	for _, f := range valuesFiles {
 		// todo copy these files and expand them
 		helmArgs = append(helmArgs, "-f", path.Join(c.rootDir, f))
 	}
 
+	if chart.ConfigChecksumValuesKey != "" {
+		helmArgs = append(helmArgs, "--set-string", chart.ConfigChecksumValuesKey+"="+namespaceConfigChecksum(c, namespace))
+	}
+
+	if c.kube.DryRun {
+		helmArgs = append(helmArgs, "--dry-run")
+	}
+
 	if chart.PostRenderer != "" {
 		helmArgs = append(helmArgs, "--post-renderer", chart.PostRenderer)
 	}
 
-	cmd := exec.Command("helm", helmArgs...)
+	cmd := c.kube.Helm(helmArgs...)
 	cmd.Dir = path.Join(c.rootDir, folder)
 
 	return runCommand(cmd)
 }
+
+func writeVarsValuesFile(c context, chart HelmChart, vars Vars) (string, error) {
+	b, err := yaml.Marshal(map[string]interface{}{
+		chart.VarsValuesKey: vars,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal vars as helm values: %w", err)
+	}
+
+	varsFile := fmt.Sprintf("/kube-deploy-vars-%s.yaml", chart.ReleaseName)
+
+	err = afero.WriteFile(c.fs, varsFile, b, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to write vars helm values file: %w", err)
+	}
+
+	return varsFile, nil
+}
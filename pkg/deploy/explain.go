@@ -0,0 +1,221 @@
+package deploy
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+type configLayer struct {
+	Name  string
+	Value interface{}
+	// the yaml the layer was read from, only the keys set in it are explained by the layer.
+	// it is nil when every value in Value was set by the layer
+	raw map[string]interface{}
+}
+
+// ExplainedValue is a final config value along with the layer that set it
+type ExplainedValue struct {
+	Key   string
+	Value interface{}
+	Layer string
+	// every layer that set the value in merge order, the last one is Layer
+	Layers []string
+}
+
+func (d *Deploy) addLayer(name string, value interface{}) {
+	d.layers = append(d.layers, configLayer{
+		Name:  name,
+		Value: value,
+	})
+}
+
+// Explain configures the deploy for target without deploying and returns every config value with the layers it came from.
+// vars are reported with their resolved value, after valueFrom and ${VAR} references are resolved
+func (d *Deploy) Explain(target string) ([]ExplainedValue, error) {
+	err := d.Configure(target)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.explain()
+}
+
+func (d *Deploy) explain() ([]ExplainedValue, error) {
+	values := make(map[string]ExplainedValue)
+
+	for _, l := range d.layers {
+		flat, err := l.setValues()
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := range flat {
+			values[k] = ExplainedValue{
+				Key:    k,
+				Value:  v,
+				Layer:  l.Name,
+				Layers: append(values[k].Layers, l.Name),
+			}
+		}
+	}
+
+	resolvedVars, err := flattenJSON(map[string]interface{}{"Vars": d.Vars})
+	if err != nil {
+		return nil, err
+	}
+
+	// layers have the raw vars, like valueFrom sources, so they are replaced by the resolved vars
+	varLayers := make(map[string]ExplainedValue)
+
+	for k, v := range values {
+		if strings.HasPrefix(k, "Vars.") {
+			varLayers[k] = v
+			delete(values, k)
+		}
+	}
+
+	for k, v := range resolvedVars {
+		explained := ExplainedValue{Key: k, Value: v}
+
+		for _, raw := range varLayers {
+			if raw.Key == k || strings.HasPrefix(raw.Key, k+".") || strings.HasPrefix(k, raw.Key+".") {
+				explained.Layers = mergeLayerNames(d.layers, explained.Layers, raw.Layers)
+			}
+		}
+
+		if len(explained.Layers) != 0 {
+			explained.Layer = explained.Layers[len(explained.Layers)-1]
+		}
+
+		values[k] = explained
+	}
+
+	explained := make([]ExplainedValue, 0, len(values))
+	for _, v := range values {
+		explained = append(explained, v)
+	}
+
+	sort.Slice(explained, func(i, j int) bool {
+		return explained[i].Key < explained[j].Key
+	})
+
+	return explained, nil
+}
+
+// setValues returns the flattened values the layer sets. values the layer doesn't set in its yaml and zero values,
+// like false or the first value of an enum, are skipped since they never override lower layers when merging
+func (l configLayer) setValues() (map[string]interface{}, error) {
+	flat, err := flattenJSON(l.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	zero, err := flattenJSON(reflect.Zero(reflect.TypeOf(l.Value)).Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	var rawKeys map[string]interface{}
+
+	if l.raw != nil {
+		rawKeys, err = flattenJSON(l.raw)
+		if err != nil {
+			return nil, err
+		}
+
+		rawKeys = lowerKeys(rawKeys)
+	}
+
+	for k, v := range flat {
+		if zeroValue, ok := zero[k]; ok && reflect.DeepEqual(zeroValue, v) {
+			delete(flat, k)
+			continue
+		}
+
+		// yaml keys are matched case insensitively, the same as when the yaml is parsed
+		if _, ok := rawKeys[strings.ToLower(k)]; l.raw != nil && !ok {
+			delete(flat, k)
+		}
+	}
+
+	return flat, nil
+}
+
+func lowerKeys(m map[string]interface{}) map[string]interface{} {
+	lower := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		lower[strings.ToLower(k)] = v
+	}
+
+	return lower
+}
+
+// mergeLayerNames merges two lists of layer names keeping the order the layers were merged in
+func mergeLayerNames(layers []configLayer, a []string, b []string) []string {
+	set := make(map[string]bool)
+	for _, name := range append(a, b...) {
+		set[name] = true
+	}
+
+	merged := []string{}
+
+	for _, l := range layers {
+		if set[l.Name] {
+			merged = append(merged, l.Name)
+			delete(set, l.Name)
+		}
+	}
+
+	return merged
+}
+
+func flattenJSON(v interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw interface{}
+
+	err = json.Unmarshal(b, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	flat := make(map[string]interface{})
+	flattenValue("", raw, flat)
+
+	return flat, nil
+}
+
+// flattenValue flattens nested maps into dot separated keys, lists are replaced as a whole when merging so they are kept as values.
+// empty values are skipped since they never override anything
+func flattenValue(prefix string, v interface{}, flat map[string]interface{}) {
+	switch val := v.(type) {
+	case nil:
+		return
+	case string:
+		if val == "" {
+			return
+		}
+	case []interface{}:
+		if len(val) == 0 {
+			return
+		}
+	case map[string]interface{}:
+		for k, item := range val {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+
+			flattenValue(key, item, flat)
+		}
+
+		return
+	}
+
+	flat[prefix] = v
+}
@@ -0,0 +1,91 @@
+package deploy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestExplainMatchesMergedDeploy(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	files := map[string]string{
+		"global_vars.yml": "global_vars: {G: global}\n",
+		"app/base.yml": `failurePolicy: continue
+kubeContext: base-ctx
+vars: {A: base, B: base}
+`,
+		"app/metadata.yml": `include: [base.yml]
+parallelClusters: true
+namespace: web
+vars: {B: meta}
+targets:
+- name: prod
+  failurePolicy: halt
+  parallelClusters: false
+  namespace: web-prod
+  vars: {C: "${B}-prod"}
+`,
+	}
+
+	for name, content := range files {
+		if err := afero.WriteFile(fs, name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := &Deploy{}
+	d.srcFs = fs
+
+	err := d.ConfigureFolderFromMetadata("app", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	explained, err := d.explain()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := flattenJSON(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make(map[string]ExplainedValue)
+
+	for _, v := range explained {
+		values[v.Key] = v
+
+		if expected, ok := merged[v.Key]; ok && !reflect.DeepEqual(expected, v.Value) {
+			t.Errorf("explained %s = %v from %s, but the deploy has %v", v.Key, v.Value, v.Layer, expected)
+		}
+	}
+
+	for key, layer := range map[string]string{
+		"FailurePolicy": "app/base.yml",
+		"KubeContext":   "app/base.yml",
+		"Namespace":     "target prod",
+		// a target can turn parallel clusters off
+		"ParallelClusters": "target prod",
+		"Vars.A":           "app/base.yml",
+		"Vars.B":           "app/metadata.yml",
+		"Vars.C":           "target prod",
+		"Vars.G":           "global_vars.yml",
+	} {
+		v, ok := values[key]
+		if !ok {
+			t.Errorf("expected %s to be explained", key)
+			continue
+		}
+
+		if v.Layer != layer {
+			t.Errorf("expected %s to come from %s, got %s", key, layer, v.Layer)
+		}
+	}
+
+	if values["Vars.C"].Value != "meta-prod" {
+		t.Errorf("expected Vars.C to be resolved, got %v", values["Vars.C"].Value)
+	}
+}
@@ -9,7 +9,7 @@ import (
 	"github.com/spf13/afero"
 )
 
-type fileProcessor func(path string, src string) string
+type fileProcessor func(path string, src string) (string, error)
 
 func listAllFilesInFolder(srcFs afero.Fs, folder string) ([]string, error) {
 	files := make([]string, 0)
@@ -64,7 +64,11 @@ func copyAndProcessFolder(srcFs afero.Fs, srcFolder string, destFs afero.Fs, des
 				return err
 			}
 
-			processedSrc := processor(f.Name(), string(s))
+			processedSrc, err := processor(f.Name(), string(s))
+			if err != nil {
+				return err
+			}
+
 			if processedSrc == "" {
 				return nil
 			}
@@ -0,0 +1,501 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/bcaldwell/kube-deploy/pkg/lib/logger"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/spf13/afero"
+)
+
+// configCommitVar is a built-in var with the commit sha the config was read from
+const configCommitVar = "CONFIG_COMMIT_SHA"
+
+var commitSHARegex = regexp.MustCompile(`^[0-9a-fA-F]{4,40}$`)
+
+// cloneConfigRepo clones ConfigRepo at ConfigRef and checks out only ConfigFolder, the files in its parent folders and the
+// files outside of it the config uses, which is all the config needs. paths are kept relative to the repo root the same as for local config folders
+func (d *Deploy) cloneConfigRepo() (afero.Fs, error) {
+	d.ConfigFolder = path.Clean(strings.TrimPrefix(d.ConfigFolder, "/"))
+
+	repo, hash, done, err := d.fetchRepo(d.ConfigRepo, d.ConfigRef, d.ConfigDepth)
+	if err != nil {
+		return nil, err
+	}
+
+	// the cache lock is held until the config is checked out so another process can't change the cache while it is read
+	defer done()
+
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit %s: %w", hash, err)
+	}
+
+	err = d.verifyConfigSignature(repo, commit)
+	if err != nil {
+		return nil, err
+	}
+
+	d.ConfigCommit = commit.Hash.String()
+
+	logger.Log("cloned config repo %s at %s", d.ConfigRepo, d.ConfigCommit)
+
+	fs := afero.NewMemMapFs()
+
+	err = d.newCheckout(d.ConfigRepo, commit, fs, "").sparseCheckout(d.ConfigFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+// fetchRepo clones url at ref into memory, or fetches it into the repo cache when ConfigCacheDir is set.
+// done has to be called once the repo isn't used anymore
+func (d *Deploy) fetchRepo(url string, ref string, depth int) (*git.Repository, plumbing.Hash, func(), error) {
+	if d.ConfigCacheDir == "" {
+		auth, err := getGitAuth(url)
+		if err != nil {
+			return nil, plumbing.ZeroHash, nil, err
+		}
+
+		repo, hash, err := cloneRef(url, ref, depth, auth)
+
+		return repo, hash, func() {}, err
+	}
+
+	cache, err := openCachedRepo(d.ConfigCacheDir, url)
+	if err != nil {
+		return nil, plumbing.ZeroHash, nil, err
+	}
+
+	repo, hash, err := cache.fetchRef(ref)
+	if err != nil {
+		cache.close()
+		return nil, plumbing.ZeroHash, nil, err
+	}
+
+	return repo, hash, cache.close, nil
+}
+
+// cloneRef clones url at ref, which can be a branch, tag or commit sha, and returns the commit it resolved to.
+// branches and tags are cloned with depth, commits can't be fetched directly so the whole repo is cloned for them
+func cloneRef(url string, ref string, depth int, auth transport.AuthMethod) (*git.Repository, plumbing.Hash, error) {
+	opts := &git.CloneOptions{
+		URL:          url,
+		Auth:         auth,
+		Depth:        depth,
+		SingleBranch: true,
+		Tags:         git.NoTags,
+	}
+
+	sha := ""
+
+	refs, err := listRemoteRefs(url, auth)
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+
+	if ref == "" {
+		// HEAD has to be resolved here since single branch clones of HEAD assume the default branch is master
+		opts.ReferenceName = findRef(refs, plumbing.HEAD.String())
+	} else {
+		refName := findRef(refs, ref)
+
+		switch {
+		case refName != "":
+			opts.ReferenceName = refName
+		case commitSHARegex.MatchString(ref):
+			logger.Log("%s is not a branch or tag, cloning the full repo to find the commit", ref)
+
+			opts.Depth = 0
+			opts.SingleBranch = false
+			opts.Tags = git.AllTags
+			sha = strings.ToLower(ref)
+		default:
+			return nil, plumbing.ZeroHash, fmt.Errorf("ref %s is not a branch, tag or commit in %s", ref, url)
+		}
+	}
+
+	// no worktree is needed since only the config folder is checked out from the commit
+	repo, err := git.Clone(memory.NewStorage(), nil, opts)
+	if err != nil {
+		return nil, plumbing.ZeroHash, fmt.Errorf("failed to clone %s: %w", url, err)
+	}
+
+	if sha != "" {
+		hash, err := findCommit(repo, sha)
+		return repo, hash, err
+	}
+
+	// HEAD points at the cloned branch or tag
+	hash, err := repo.ResolveRevision(plumbing.Revision(plumbing.HEAD))
+	if err != nil {
+		return nil, plumbing.ZeroHash, fmt.Errorf("failed to resolve HEAD in %s: %w", url, err)
+	}
+
+	return repo, *hash, nil
+}
+
+// findCommit finds the commit starting with the possibly abbreviated sha
+func findCommit(repo *git.Repository, sha string) (plumbing.Hash, error) {
+	commits, err := repo.CommitObjects()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	matches := []plumbing.Hash{}
+
+	err = commits.ForEach(func(c *object.Commit) error {
+		if strings.HasPrefix(c.Hash.String(), sha) {
+			matches = append(matches, c.Hash)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	switch len(matches) {
+	case 0:
+		return plumbing.ZeroHash, fmt.Errorf("commit %s not found", sha)
+	case 1:
+		return matches[0], nil
+	}
+
+	return plumbing.ZeroHash, fmt.Errorf("commit %s is ambiguous, it matches %d commits", sha, len(matches))
+}
+
+func listRemoteRefs(url string, auth transport.AuthMethod) ([]*plumbing.Reference, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{url},
+	})
+
+	refs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs in %s: %w", url, err)
+	}
+
+	return refs, nil
+}
+
+// findRef returns the full name of the branch or tag called ref, or an empty name when there is none.
+// symbolic refs, like HEAD, return the name of the ref they point to
+func findRef(refs []*plumbing.Reference, ref string) plumbing.ReferenceName {
+	candidates := []plumbing.ReferenceName{
+		plumbing.ReferenceName(ref),
+		plumbing.NewBranchReferenceName(ref),
+		plumbing.NewTagReferenceName(ref),
+	}
+
+	for _, candidate := range candidates {
+		for _, r := range refs {
+			if r.Name() != candidate {
+				continue
+			}
+
+			if r.Type() == plumbing.SymbolicReference {
+				return r.Target()
+			}
+
+			return candidate
+		}
+	}
+
+	return ""
+}
+
+// checkout writes files from a commit into fs, checking out submodules and replacing git lfs pointers with their objects
+type checkout struct {
+	d      *Deploy
+	url    string
+	commit *object.Commit
+	fs     afero.Fs
+	// path in fs the repo is checked out at, it is empty for the config repo and the submodule path for submodules
+	prefix string
+	// lfs pointers that were checked out by their path in fs
+	lfsPointers map[string]lfsPointer
+}
+
+func (d *Deploy) newCheckout(url string, commit *object.Commit, fs afero.Fs, prefix string) *checkout {
+	return &checkout{
+		d:           d,
+		url:         url,
+		commit:      commit,
+		fs:          fs,
+		prefix:      prefix,
+		lfsPointers: make(map[string]lfsPointer),
+	}
+}
+
+// sparseCheckout writes folder and the files directly in each of its parent folders, like global_vars.yml, from the commit into fs.
+// files outside of folder that the config uses, like included metadata, valueFrom files and helm values files, are written too
+func (c *checkout) sparseCheckout(folder string) error {
+	root, err := c.commit.Tree()
+	if err != nil {
+		return err
+	}
+
+	hierarchy := folderHierarchy(folder)
+
+	for _, dir := range hierarchy[:len(hierarchy)-1] {
+		tree, err := subtree(root, dir)
+		if err != nil {
+			return c.folderError(root, folder, err)
+		}
+
+		for _, entry := range tree.Entries {
+			if !entry.Mode.IsFile() {
+				continue
+			}
+
+			file, err := tree.TreeEntryFile(&entry)
+			if err != nil {
+				return err
+			}
+
+			err = c.checkoutFile(path.Join(dir, entry.Name), file)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	tree, err := subtree(root, folder)
+	if err != nil {
+		return c.folderError(root, folder, err)
+	}
+
+	err = c.checkoutTree(tree, folder)
+	if err != nil {
+		return err
+	}
+
+	err = c.checkoutDependencies(root, folder)
+	if err != nil {
+		return err
+	}
+
+	return c.fetchLFSObjects()
+}
+
+// checkoutDependencies writes the files outside of folder that its metadata.yml uses.
+// includes are checked out first since the metadata can't be loaded to find the other files until they exist
+func (c *checkout) checkoutDependencies(root *object.Tree, folder string) error {
+	metadataFile := path.Join(folder, "metadata.yml")
+
+	if _, err := c.fs.Stat(metadataFile); os.IsNotExist(err) {
+		return nil
+	}
+
+	err := c.checkoutIncludes(root, metadataFile, make(map[string]bool))
+	if err != nil {
+		return err
+	}
+
+	dependencies, err := folderDependencies(c.fs, folder, globalVarsEnvFile())
+	if err != nil {
+		return fmt.Errorf("failed to find the files %s depends on: %w", folder, err)
+	}
+
+	for _, dependency := range dependencies {
+		err = c.checkoutPath(root, dependency.file)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkoutIncludes writes file and every metadata file it includes, directly or through other includes
+func (c *checkout) checkoutIncludes(root *object.Tree, file string, seen map[string]bool) error {
+	if seen[file] {
+		return nil
+	}
+
+	seen[file] = true
+
+	err := c.checkoutPath(root, file)
+	if err != nil {
+		return err
+	}
+
+	m := Metadata{}
+
+	// missing and invalid includes are reported when the metadata is loaded
+	if readAndUnmarshal(c.fs, &m, file) != nil {
+		return nil
+	}
+
+	for _, include := range m.Include {
+		err = c.checkoutIncludes(root, path.Join(path.Dir(file), include), seen)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkoutPath writes the file at name in the repo unless it was already checked out, files that don't exist are skipped
+func (c *checkout) checkoutPath(root *object.Tree, name string) error {
+	if _, err := c.fs.Stat(path.Join(c.prefix, name)); err == nil {
+		return nil
+	}
+
+	file, err := root.File(name)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !file.Mode.IsFile() {
+		return nil
+	}
+
+	return c.checkoutFile(name, file)
+}
+
+// folderError explains why a folder couldn't be found in the commit, submodules can't be part of the config folder path
+func (c *checkout) folderError(root *object.Tree, folder string, err error) error {
+	if !errors.Is(err, object.ErrDirectoryNotFound) {
+		return err
+	}
+
+	for _, dir := range folderHierarchy(folder) {
+		entry, err := root.FindEntry(dir)
+		if err == nil && entry.Mode == filemode.Submodule {
+			return fmt.Errorf("config folder %s is inside of submodule %s, the config folder has to be in the config repo", folder, dir)
+		}
+	}
+
+	return fmt.Errorf("config folder either doesnt exist or is not a directory: %s", folder)
+}
+
+// checkoutTree writes every file in tree, which is at dir in the repo, and checks out the submodules in it
+func (c *checkout) checkoutTree(tree *object.Tree, dir string) error {
+	for _, entry := range tree.Entries {
+		name := path.Join(dir, entry.Name)
+
+		switch {
+		case entry.Mode == filemode.Dir:
+			sub, err := tree.Tree(entry.Name)
+			if err != nil {
+				return err
+			}
+
+			err = c.checkoutTree(sub, name)
+			if err != nil {
+				return err
+			}
+		case entry.Mode == filemode.Submodule:
+			err := c.checkoutSubmodule(name, entry.Hash)
+			if err != nil {
+				return err
+			}
+		case entry.Mode.IsFile():
+			file, err := tree.TreeEntryFile(&entry)
+			if err != nil {
+				return err
+			}
+
+			err = c.checkoutFile(name, file)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func subtree(root *object.Tree, dir string) (*object.Tree, error) {
+	if dir == "." {
+		return root, nil
+	}
+
+	return root.Tree(dir)
+}
+
+func (c *checkout) checkoutFile(name string, file *object.File) error {
+	name = path.Join(c.prefix, name)
+
+	mode, err := file.Mode.ToOSFileMode()
+	if err != nil {
+		return err
+	}
+
+	err = c.fs.MkdirAll(path.Dir(name), 0755)
+	if err != nil {
+		return err
+	}
+
+	reader, err := file.Reader()
+	if err != nil {
+		return err
+	}
+
+	defer reader.Close()
+
+	f, err := c.fs.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	// the pointer is written out and replaced once every lfs object in the repo is fetched
+	if file.Size <= lfsPointerMaxSize {
+		content, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+
+		if pointer, ok := parseLFSPointer(content); ok {
+			pointer.mode = mode
+			c.lfsPointers[name] = pointer
+		}
+
+		_, err = f.Write(content)
+
+		return err
+	}
+
+	_, err = io.Copy(f, reader)
+
+	return err
+}
+
+// localCommit returns the commit checked out in a local git repo, it is empty when it can't be read
+func localCommit(gitRoot string) string {
+	repo, err := git.PlainOpen(gitRoot)
+	if err != nil {
+		return ""
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return ""
+	}
+
+	return head.Hash().String()
+}
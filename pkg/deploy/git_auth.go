@@ -0,0 +1,147 @@
+package deploy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/bcaldwell/kube-deploy/pkg/lib/logger"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// defaultGitTokenUsername is used with GIT_TOKEN when GIT_USERNAME isn't set, hosts like github accept any username with a token
+const defaultGitTokenUsername = "x-access-token"
+
+// getGitAuth picks the auth method for the url's scheme from the environment:
+//
+//	https: GIT_TOKEN with GIT_USERNAME as basic auth, anonymous when GIT_TOKEN isn't set
+//	ssh: the private key in GIT_SSH_KEY or the file in GIT_SSH_KEY_PATH, unlocked with GIT_SSH_KEY_PASSPHRASE, or the ssh agent.
+//	     host keys are verified against GIT_SSH_KNOWN_HOSTS, the file in GIT_SSH_KNOWN_HOSTS_PATH or the default known_hosts files
+//	git and file: no auth
+func getGitAuth(url string) (transport.AuthMethod, error) {
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid git url %s: %w", url, err)
+	}
+
+	switch endpoint.Protocol {
+	case "http", "https":
+		return getGitHTTPAuth()
+	case "ssh":
+		user := endpoint.User
+		if user == "" {
+			user = "git"
+		}
+
+		return getGitSSHAuth(user)
+	}
+
+	return nil, nil
+}
+
+func getGitHTTPAuth() (transport.AuthMethod, error) {
+	token := os.Getenv("GIT_TOKEN")
+	if token == "" {
+		return nil, nil
+	}
+
+	username := os.Getenv("GIT_USERNAME")
+	if username == "" {
+		username = defaultGitTokenUsername
+	}
+
+	logger.Log("using GIT_TOKEN to authenticate as %s", username)
+
+	return &http.BasicAuth{
+		Username: username,
+		Password: token,
+	}, nil
+}
+
+func getGitSSHAuth(user string) (transport.AuthMethod, error) {
+	hostKeyCallback, err := getKnownHostsCallback()
+	if err != nil {
+		return nil, err
+	}
+
+	key := []byte(os.Getenv("GIT_SSH_KEY"))
+	source := "GIT_SSH_KEY"
+
+	if keyPath := os.Getenv("GIT_SSH_KEY_PATH"); len(key) == 0 && keyPath != "" {
+		key, err = ioutil.ReadFile(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ssh key from GIT_SSH_KEY_PATH: %w", err)
+		}
+
+		source = fmt.Sprintf("GIT_SSH_KEY_PATH (%s)", keyPath)
+	}
+
+	if len(key) != 0 {
+		auth, err := ssh.NewPublicKeys(user, key, os.Getenv("GIT_SSH_KEY_PASSPHRASE"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ssh key from %s: %w", source, err)
+		}
+
+		logger.Log("using ssh key from %s", source)
+
+		auth.HostKeyCallback = hostKeyCallback
+
+		return auth, nil
+	}
+
+	// https://github.com/src-d/go-git/issues/637
+	auth, err := ssh.NewSSHAgentAuth(user)
+	if err != nil {
+		return nil, fmt.Errorf("no ssh key set in GIT_SSH_KEY or GIT_SSH_KEY_PATH and the ssh agent isn't available: %w", err)
+	}
+
+	auth.HostKeyCallback = hostKeyCallback
+
+	return auth, nil
+}
+
+// getKnownHostsCallback verifies host keys against GIT_SSH_KNOWN_HOSTS, GIT_SSH_KNOWN_HOSTS_PATH or the default known_hosts files
+func getKnownHostsCallback() (gossh.HostKeyCallback, error) {
+	if knownHosts := os.Getenv("GIT_SSH_KNOWN_HOSTS"); knownHosts != "" {
+		// known hosts can only be loaded from files, they are read when the callback is created so the file can be removed after
+		f, err := ioutil.TempFile(os.TempDir(), "kube-deploy-known-hosts")
+		if err != nil {
+			return nil, err
+		}
+
+		defer os.Remove(f.Name())
+
+		_, err = f.WriteString(knownHosts)
+		f.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		callback, err := ssh.NewKnownHostsCallback(f.Name())
+		if err != nil {
+			return nil, fmt.Errorf("invalid GIT_SSH_KNOWN_HOSTS: %w", err)
+		}
+
+		return callback, nil
+	}
+
+	if knownHostsPath := os.Getenv("GIT_SSH_KNOWN_HOSTS_PATH"); knownHostsPath != "" {
+		callback, err := ssh.NewKnownHostsCallback(knownHostsPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load known hosts from GIT_SSH_KNOWN_HOSTS_PATH: %w", err)
+		}
+
+		return callback, nil
+	}
+
+	callback, err := ssh.NewKnownHostsCallback()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known_hosts, set GIT_SSH_KNOWN_HOSTS or GIT_SSH_KNOWN_HOSTS_PATH: %w", err)
+	}
+
+	return callback, nil
+}
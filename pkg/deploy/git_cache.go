@@ -0,0 +1,167 @@
+package deploy
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/bcaldwell/kube-deploy/pkg/lib/logger"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// cachedRepo is a bare clone of a config repo on disk that is fetched into incrementally
+type cachedRepo struct {
+	url  string
+	dir  string
+	lock *os.File
+}
+
+// openCachedRepo opens or creates the cached clone of url in cacheDir and locks it until close is called,
+// so concurrent kube-deploy processes using the same cache wait for each other instead of corrupting it
+func openCachedRepo(cacheDir string, url string) (*cachedRepo, error) {
+	err := os.MkdirAll(cacheDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create repo cache dir: %w", err)
+	}
+
+	key := fmt.Sprintf("%x", sha256.Sum256([]byte(url)))
+
+	c := &cachedRepo{
+		url: url,
+		dir: filepath.Join(cacheDir, key+".git"),
+	}
+
+	c.lock, err = os.OpenFile(filepath.Join(cacheDir, key+".lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repo cache lock: %w", err)
+	}
+
+	err = syscall.Flock(int(c.lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		logger.Log("waiting for another kube-deploy process to release the cached clone of %s", url)
+
+		err = syscall.Flock(int(c.lock.Fd()), syscall.LOCK_EX)
+	}
+
+	if err != nil {
+		c.lock.Close()
+		return nil, fmt.Errorf("failed to lock repo cache: %w", err)
+	}
+
+	return c, nil
+}
+
+func (c *cachedRepo) close() {
+	syscall.Flock(int(c.lock.Fd()), syscall.LOCK_UN)
+	c.lock.Close()
+}
+
+func (c *cachedRepo) open() (*git.Repository, error) {
+	repo, err := git.PlainOpen(c.dir)
+	if err == nil {
+		return repo, nil
+	}
+
+	if !errors.Is(err, git.ErrRepositoryNotExists) {
+		return nil, fmt.Errorf("failed to open cached clone of %s in %s: %w", c.url, c.dir, err)
+	}
+
+	logger.Log("creating cached clone of %s in %s", c.url, c.dir)
+
+	repo, err = git.PlainInit(c.dir, true)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = repo.CreateRemote(&config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{c.url},
+	})
+
+	return repo, err
+}
+
+// fetchRef fetches ref, which can be a branch, tag or commit sha, into the cached clone and returns the commit it resolved to.
+// commits that are already cached are used without fetching since they can't change, which also works offline
+func (c *cachedRepo) fetchRef(ref string) (*git.Repository, plumbing.Hash, error) {
+	repo, err := c.open()
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+
+	if ref != "" && commitSHARegex.MatchString(ref) {
+		hash, err := findCommit(repo, strings.ToLower(ref))
+		if err == nil {
+			logger.Log("using cached commit %s of %s", hash, c.url)
+			return repo, hash, nil
+		}
+	}
+
+	auth, err := getGitAuth(c.url)
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+
+	refs, err := listRemoteRefs(c.url, auth)
+	if err != nil {
+		return nil, plumbing.ZeroHash, err
+	}
+
+	name := findRef(refs, plumbing.HEAD.String())
+	if ref != "" {
+		name = findRef(refs, ref)
+	}
+
+	var refSpecs []config.RefSpec
+
+	switch {
+	case name != "":
+		refSpecs = []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", name, name))}
+	case ref != "" && commitSHARegex.MatchString(ref):
+		logger.Log("%s is not a branch or tag, fetching every branch and tag to find the commit", ref)
+
+		refSpecs = []config.RefSpec{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"}
+	default:
+		return nil, plumbing.ZeroHash, fmt.Errorf("ref %s is not a branch, tag or commit in %s", ref, c.url)
+	}
+
+	logger.Log("fetching %s into cached clone of %s", strings.Join(refSpecStrings(refSpecs), ", "), c.url)
+
+	err = repo.Fetch(&git.FetchOptions{
+		RemoteName: git.DefaultRemoteName,
+		RefSpecs:   refSpecs,
+		Auth:       auth,
+		Tags:       git.NoTags,
+		Force:      true,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil, plumbing.ZeroHash, fmt.Errorf("failed to fetch %s: %w", c.url, err)
+	}
+
+	if name == "" {
+		hash, err := findCommit(repo, strings.ToLower(ref))
+		return repo, hash, err
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(name))
+	if err != nil {
+		return nil, plumbing.ZeroHash, fmt.Errorf("failed to resolve %s in %s: %w", name, c.url, err)
+	}
+
+	return repo, *hash, nil
+}
+
+func refSpecStrings(refSpecs []config.RefSpec) []string {
+	s := make([]string, 0, len(refSpecs))
+	for _, r := range refSpecs {
+		s = append(s, r.String())
+	}
+
+	return s
+}
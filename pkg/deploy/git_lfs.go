@@ -0,0 +1,369 @@
+package deploy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bcaldwell/kube-deploy/pkg/lib/logger"
+	"github.com/go-git/go-git/v5/plumbing/format/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/spf13/afero"
+)
+
+// lfs pointer files are small, larger files are never read to check if they are pointers
+const lfsPointerMaxSize = 1024
+
+const lfsMediaType = "application/vnd.git-lfs+json"
+
+var (
+	lfsPointerRegex = regexp.MustCompile(`^version https://(git-lfs\.github\.com|hawser\.github\.com)/spec/v1\n`)
+	lfsOIDRegex     = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	lfsHTTPClient   = &http.Client{Timeout: 10 * time.Minute}
+)
+
+// lfsPointer is a file checked into git in place of an lfs object
+type lfsPointer struct {
+	oid  string
+	size int64
+	mode os.FileMode
+}
+
+func parseLFSPointer(content []byte) (lfsPointer, bool) {
+	pointer := lfsPointer{size: -1}
+
+	if !lfsPointerRegex.Match(content) {
+		return pointer, false
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		switch parts[0] {
+		case "oid":
+			pointer.oid = strings.TrimPrefix(parts[1], "sha256:")
+		case "size":
+			size, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				return pointer, false
+			}
+
+			pointer.size = size
+		}
+	}
+
+	return pointer, lfsOIDRegex.MatchString(pointer.oid) && pointer.size >= 0
+}
+
+// fetchLFSObjects replaces the lfs pointers that were checked out with their objects.
+// objects are downloaded with the lfs batch api, or read from the lfs storage of local repos
+func (c *checkout) fetchLFSObjects() error {
+	if len(c.lfsPointers) == 0 {
+		return nil
+	}
+
+	objects := make(map[string]lfsPointer)
+	for _, pointer := range c.lfsPointers {
+		objects[pointer.oid] = pointer
+	}
+
+	endpoint, err := c.lfsEndpoint()
+	if err != nil {
+		return err
+	}
+
+	logger.Log("fetching %d lfs objects for %s from %s", len(objects), c.url, endpoint)
+
+	if dir, ok := localRepoDir(endpoint); ok {
+		return c.writeLFSObjects(func(pointer lfsPointer) (io.ReadCloser, error) {
+			return openLocalLFSObject(dir, pointer.oid)
+		})
+	}
+
+	actions, err := lfsBatch(endpoint, objects)
+	if err != nil {
+		return fmt.Errorf("failed to fetch lfs objects for %s: %w", c.url, err)
+	}
+
+	return c.writeLFSObjects(func(pointer lfsPointer) (io.ReadCloser, error) {
+		return actions[pointer.oid].download()
+	})
+}
+
+// writeLFSObjects writes the object of every pointer over the pointer, objects are checked against their pointer
+func (c *checkout) writeLFSObjects(open func(pointer lfsPointer) (io.ReadCloser, error)) error {
+	for name, pointer := range c.lfsPointers {
+		err := writeLFSObject(c.fs, name, pointer, open)
+		if err != nil {
+			return fmt.Errorf("failed to fetch lfs object %s for %s from %s: %w", pointer.oid, name, c.url, err)
+		}
+	}
+
+	return nil
+}
+
+func writeLFSObject(fs afero.Fs, name string, pointer lfsPointer, open func(pointer lfsPointer) (io.ReadCloser, error)) error {
+	reader, err := open(pointer)
+	if err != nil {
+		return err
+	}
+
+	defer reader.Close()
+
+	f, err := fs.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, pointer.mode)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	hash := sha256.New()
+
+	size, err := io.Copy(io.MultiWriter(f, hash), reader)
+	if err != nil {
+		return err
+	}
+
+	if size != pointer.size {
+		return fmt.Errorf("object is %d bytes but the pointer is for %d bytes", size, pointer.size)
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != pointer.oid {
+		return errors.New("object content doesn't match its oid")
+	}
+
+	return nil
+}
+
+// lfsEndpoint returns the lfs url from .lfsconfig, or the default lfs url for the repo
+func (c *checkout) lfsEndpoint() (string, error) {
+	file, err := c.commit.File(".lfsconfig")
+	if err != nil && !errors.Is(err, object.ErrFileNotFound) {
+		return "", err
+	}
+
+	if err == nil {
+		content, err := file.Contents()
+		if err != nil {
+			return "", err
+		}
+
+		cfg := config.New()
+
+		err = config.NewDecoder(strings.NewReader(content)).Decode(cfg)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse .lfsconfig in %s: %w", c.url, err)
+		}
+
+		if url := cfg.Section("lfs").Option("url"); url != "" {
+			return url, nil
+		}
+	}
+
+	return defaultLFSEndpoint(c.url)
+}
+
+// defaultLFSEndpoint returns the lfs url git lfs uses for a repo url, <repo>.git/info/lfs over https.
+// local repos are their own endpoint since their lfs objects are read from disk
+func defaultLFSEndpoint(url string) (string, error) {
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil {
+		return "", err
+	}
+
+	switch endpoint.Protocol {
+	case "file":
+		return url, nil
+	case "http", "https":
+		url = strings.TrimSuffix(url, "/")
+	default:
+		// ssh and git urls use the https endpoint on the same host, which needs GIT_TOKEN if the repo is private
+		url = "https://" + endpoint.Host + "/" + strings.TrimPrefix(endpoint.Path, "/")
+	}
+
+	if !strings.HasSuffix(url, ".git") {
+		url += ".git"
+	}
+
+	return url + "/info/lfs", nil
+}
+
+func localRepoDir(url string) (string, bool) {
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil || endpoint.Protocol != "file" {
+		return "", false
+	}
+
+	return endpoint.Path, true
+}
+
+// openLocalLFSObject opens an object from the lfs storage of a local repo or bare repo
+func openLocalLFSObject(dir string, oid string) (io.ReadCloser, error) {
+	for _, objects := range []string{filepath.Join(dir, ".git", "lfs", "objects"), filepath.Join(dir, "lfs", "objects")} {
+		f, err := os.Open(filepath.Join(objects, oid[0:2], oid[2:4], oid))
+		if err == nil {
+			return f, nil
+		}
+
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("object is not in the lfs storage of %s", dir)
+}
+
+type lfsBatchObject struct {
+	OID     string `json:"oid"`
+	Size    int64  `json:"size"`
+	Actions struct {
+		Download *lfsAction `json:"download"`
+	} `json:"actions,omitempty"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+type lfsAction struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header"`
+}
+
+// lfsBatch requests downloads for objects from the lfs batch api, the download action of every object is returned by oid
+func lfsBatch(endpoint string, objects map[string]lfsPointer) (map[string]*lfsAction, error) {
+	request := struct {
+		Operation string           `json:"operation"`
+		Transfers []string         `json:"transfers"`
+		Objects   []lfsBatchObject `json:"objects"`
+	}{
+		Operation: "download",
+		Transfers: []string{"basic"},
+	}
+
+	for oid, pointer := range objects {
+		request.Objects = append(request.Objects, lfsBatchObject{OID: oid, Size: pointer.size})
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint+"/objects/batch", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", lfsMediaType)
+	req.Header.Set("Content-Type", lfsMediaType)
+
+	// lfs servers take the same credentials as the repo
+	auth, err := getGitAuth(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if httpAuth, ok := auth.(githttp.AuthMethod); ok {
+		httpAuth.SetAuth(req)
+	}
+
+	resp, err := lfsHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("lfs batch request to %s failed: %w", endpoint, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("lfs batch request to %s failed with %s: %s", endpoint, resp.Status, lfsErrorMessage(resp.Body))
+	}
+
+	response := struct {
+		Objects []lfsBatchObject `json:"objects"`
+	}{}
+
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse lfs batch response from %s: %w", endpoint, err)
+	}
+
+	actions := make(map[string]*lfsAction)
+
+	for _, object := range response.Objects {
+		if object.Error != nil {
+			return nil, fmt.Errorf("lfs object %s is not available from %s: %s (%d)", object.OID, endpoint, object.Error.Message, object.Error.Code)
+		}
+
+		if object.Actions.Download == nil {
+			return nil, fmt.Errorf("lfs server %s didn't return a download for object %s", endpoint, object.OID)
+		}
+
+		actions[object.OID] = object.Actions.Download
+	}
+
+	for oid := range objects {
+		if actions[oid] == nil {
+			return nil, fmt.Errorf("lfs server %s didn't return object %s", endpoint, oid)
+		}
+	}
+
+	return actions, nil
+}
+
+func (a *lfsAction) download() (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, a.Href, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range a.Header {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := lfsHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, fmt.Errorf("download failed with %s: %s", resp.Status, lfsErrorMessage(resp.Body))
+	}
+
+	return resp.Body, nil
+}
+
+// lfsErrorMessage returns the message from an lfs error response, or the body when it isn't an lfs error
+func lfsErrorMessage(body io.Reader) string {
+	b, _ := ioutil.ReadAll(io.LimitReader(body, 4096))
+
+	lfsErr := struct {
+		Message string `json:"message"`
+	}{}
+
+	if json.Unmarshal(b, &lfsErr) == nil && lfsErr.Message != "" {
+		return lfsErr.Message
+	}
+
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,109 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"path"
+	"strings"
+
+	"github.com/bcaldwell/kube-deploy/pkg/lib/logger"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// checkoutSubmodule clones the submodule at dir in the repo and checks out all of it at the commit it is pinned to.
+// submodules are cloned with the same credentials as the config repo and their own submodules are checked out too
+func (c *checkout) checkoutSubmodule(dir string, hash plumbing.Hash) error {
+	submodules, err := c.submodules()
+	if err != nil {
+		return err
+	}
+
+	submodule, ok := submodules[dir]
+	if !ok {
+		return fmt.Errorf("submodule %s in %s is missing from .gitmodules", dir, c.url)
+	}
+
+	url := resolveSubmoduleURL(c.url, submodule.URL)
+
+	logger.Log("checking out submodule %s from %s at %s", dir, url, hash)
+
+	repo, _, done, err := c.d.fetchRepo(url, hash.String(), 0)
+	if err != nil {
+		return fmt.Errorf("failed to clone submodule %s: %w", dir, err)
+	}
+
+	defer done()
+
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return fmt.Errorf("failed to get commit %s of submodule %s: %w", hash, dir, err)
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+
+	sub := c.d.newCheckout(url, commit, c.fs, path.Join(c.prefix, dir))
+
+	err = sub.checkoutTree(tree, "")
+	if err != nil {
+		return err
+	}
+
+	return sub.fetchLFSObjects()
+}
+
+// submodules returns the submodules in .gitmodules by their path
+func (c *checkout) submodules() (map[string]*config.Submodule, error) {
+	submodules := make(map[string]*config.Submodule)
+
+	file, err := c.commit.File(".gitmodules")
+	if errors.Is(err, object.ErrFileNotFound) {
+		return submodules, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+
+	modules := config.NewModules()
+
+	err = modules.Unmarshal([]byte(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse .gitmodules in %s: %w", c.url, err)
+	}
+
+	for _, submodule := range modules.Submodules {
+		submodules[path.Clean(submodule.Path)] = submodule
+	}
+
+	return submodules, nil
+}
+
+// resolveSubmoduleURL resolves relative submodule urls, like ../charts.git, against the url of the repo they are in
+func resolveSubmoduleURL(repoURL string, url string) string {
+	if !strings.HasPrefix(url, "./") && !strings.HasPrefix(url, "../") {
+		return url
+	}
+
+	if u, err := neturl.Parse(repoURL); err == nil && u.Scheme != "" {
+		u.Path = path.Join(u.Path, url)
+		return u.String()
+	}
+
+	// scp like ssh urls, git@github.com:org/repo.git
+	if i := strings.Index(repoURL, ":"); i > 0 {
+		return repoURL[:i+1] + path.Join(repoURL[i+1:], url)
+	}
+
+	return path.Join(repoURL, url)
+}
@@ -0,0 +1,125 @@
+package deploy
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/spf13/afero"
+)
+
+// storeTree stores files, keyed by their path, as nested trees in the repo and returns the root tree hash
+func storeTree(t *testing.T, repo *git.Repository, files map[string]string) plumbing.Hash {
+	t.Helper()
+
+	dirs := map[string]map[string]string{}
+	tree := &object.Tree{}
+
+	for name, content := range files {
+		parts := strings.SplitN(name, "/", 2)
+		if len(parts) == 2 {
+			if dirs[parts[0]] == nil {
+				dirs[parts[0]] = map[string]string{}
+			}
+
+			dirs[parts[0]][parts[1]] = content
+
+			continue
+		}
+
+		blob := repo.Storer.NewEncodedObject()
+		blob.SetType(plumbing.BlobObject)
+
+		w, err := blob.Writer()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+
+		w.Close()
+
+		hash, err := repo.Storer.SetEncodedObject(blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tree.Entries = append(tree.Entries, object.TreeEntry{Name: name, Mode: filemode.Regular, Hash: hash})
+	}
+
+	for dir, dirFiles := range dirs {
+		tree.Entries = append(tree.Entries, object.TreeEntry{Name: dir, Mode: filemode.Dir, Hash: storeTree(t, repo, dirFiles)})
+	}
+
+	// git sorts tree entries by name
+	sort.Slice(tree.Entries, func(i, j int) bool { return tree.Entries[i].Name < tree.Entries[j].Name })
+
+	return storeObject(t, repo, tree)
+}
+
+func TestSparseCheckout(t *testing.T) {
+	repo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	author := object.Signature{Name: "t", Email: "t@example.com", When: time.Unix(0, 0)}
+
+	tree := storeTree(t, repo, map[string]string{
+		"global_vars.yml": "global_vars: {A: a}\n",
+		"apps/web/metadata.yml": `include: [../shared/base.yml]
+helm: {name: web, valuesFiles: [../../../charts/values.yml]}
+vars:
+  TOKEN: {valueFrom: {file: ../secrets/token.txt}}
+`,
+		"apps/web/deploy/deployment.yml": "kind: Deployment\n",
+		"apps/web/helmvalues/values.yml": "replicas: 1\n",
+		"apps/shared/base.yml":           "include: [nested.yml]\n",
+		"apps/shared/nested.yml":         "vars: {B: b}\n",
+		"apps/shared/unused.yml":         "vars: {C: c}\n",
+		"apps/secrets/token.txt":         "s3cret\n",
+		"apps/other/metadata.yml":        "namespace: other\n",
+		"charts/values.yml":              "replicas: 2\n",
+	})
+
+	hash := storeObject(t, repo, &object.Commit{Author: author, Committer: author, Message: "config", TreeHash: tree})
+
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := afero.NewMemMapFs()
+
+	err = (&Deploy{}).newCheckout("", commit, fs, "").sparseCheckout("apps/web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]bool{
+		"global_vars.yml":                true,
+		"apps/web/metadata.yml":          true,
+		"apps/web/deploy/deployment.yml": true,
+		// includes are followed through included files
+		"apps/shared/base.yml":    true,
+		"apps/shared/nested.yml":  true,
+		"apps/secrets/token.txt":  true,
+		"charts/values.yml":       true,
+		"apps/shared/unused.yml":  false,
+		"apps/other/metadata.yml": false,
+	} {
+		_, err := fs.Stat(path.Clean(name))
+		if exists := err == nil; exists != expected {
+			t.Errorf("expected %s checked out to be %t, got %t", name, expected, exists)
+		}
+	}
+}
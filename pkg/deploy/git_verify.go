@@ -0,0 +1,261 @@
+package deploy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/bcaldwell/kube-deploy/pkg/lib/logger"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const (
+	sshSignatureStart = "-----BEGIN SSH SIGNATURE-----"
+	// namespace git uses when signing with ssh keys
+	sshSignatureNamespace = "git"
+)
+
+var errNotSigned = errors.New("not signed")
+
+// signedObject is a commit or tag payload with its signature
+type signedObject struct {
+	description string
+	payload     []byte
+	signature   string
+}
+
+// verifyConfigSignature checks that the tag ref points at, or otherwise the commit, has a valid signature from
+// ConfigKeyring or ConfigAllowedSigners. the tag is only used when it points at the commit being deployed, so a branch
+// with the same name as a signed tag needs a signed commit. it is a no-op when neither is set
+func (d *Deploy) verifyConfigSignature(repo *git.Repository, commit *object.Commit) error {
+	if d.ConfigKeyring == "" && d.ConfigAllowedSigners == "" {
+		return nil
+	}
+
+	tag, err := signedTag(repo, d.ConfigRef, commit.Hash)
+	if err != nil {
+		return err
+	}
+
+	if tag != nil {
+		signer, err := d.verifySignature(*tag)
+		if err == nil {
+			logger.Log("%s is signed by %s", tag.description, signer)
+			return nil
+		}
+
+		// an unsigned tag still allows a signed commit, a bad signature doesn't
+		if !errors.Is(err, errNotSigned) {
+			return fmt.Errorf("refusing to deploy, %s has an invalid signature: %w", tag.description, err)
+		}
+	}
+
+	signed, err := signedCommit(commit)
+	if err != nil {
+		return err
+	}
+
+	signer, err := d.verifySignature(signed)
+	if err != nil {
+		return fmt.Errorf("refusing to deploy, %s does not have a valid signature: %w", signed.description, err)
+	}
+
+	logger.Log("%s is signed by %s", signed.description, signer)
+
+	return nil
+}
+
+func (d *Deploy) verifySignature(o signedObject) (string, error) {
+	switch {
+	case o.signature == "":
+		return "", errNotSigned
+	case strings.HasPrefix(o.signature, sshSignatureStart):
+		if d.ConfigAllowedSigners == "" {
+			return "", errors.New("it is signed with an ssh key but no allowed signers file is set")
+		}
+
+		return verifySSHSignature(d.ConfigAllowedSigners, o)
+	}
+
+	if d.ConfigKeyring == "" {
+		return "", errors.New("it is signed with a gpg key but no keyring is set")
+	}
+
+	return verifyGPGSignature(d.ConfigKeyring, o)
+}
+
+// signedTag returns the annotated tag called ref, nil when ref isn't an annotated tag or the tag doesn't point at commit
+func signedTag(repo *git.Repository, ref string, commit plumbing.Hash) (*signedObject, error) {
+	if ref == "" {
+		return nil, nil
+	}
+
+	tagRef, err := repo.Reference(plumbing.NewTagReferenceName(strings.TrimPrefix(ref, "refs/tags/")), false)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := repo.TagObject(tagRef.Hash())
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		// lightweight tags point directly at the commit
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// ref resolved to a branch with the same name, or the tag points at another tag, so the tag doesn't vouch for commit
+	if tag.Target != commit {
+		logger.Log("tag %s points at %s instead of the deployed commit %s, verifying the commit", tag.Name, tag.Target, commit)
+		return nil, nil
+	}
+
+	signature := tag.PGPSignature
+
+	// go-git only splits pgp signatures from the tag message, ssh signatures are left at the end of it
+	if i := strings.Index(tag.Message, sshSignatureStart); signature == "" && i != -1 {
+		unsigned := *tag
+		unsigned.Message = tag.Message[:i]
+		signature = tag.Message[i:]
+		tag = &unsigned
+	}
+
+	encoded := &plumbing.MemoryObject{}
+
+	err = tag.EncodeWithoutSignature(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := readEncodedObject(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signedObject{
+		description: fmt.Sprintf("tag %s", tag.Name),
+		payload:     payload,
+		signature:   signature,
+	}, nil
+}
+
+func signedCommit(commit *object.Commit) (signedObject, error) {
+	encoded := &plumbing.MemoryObject{}
+
+	err := commit.EncodeWithoutSignature(encoded)
+	if err != nil {
+		return signedObject{}, err
+	}
+
+	payload, err := readEncodedObject(encoded)
+	if err != nil {
+		return signedObject{}, err
+	}
+
+	return signedObject{
+		description: fmt.Sprintf("commit %s", commit.Hash),
+		payload:     payload,
+		signature:   commit.PGPSignature,
+	}, nil
+}
+
+func readEncodedObject(o *plumbing.MemoryObject) ([]byte, error) {
+	r, err := o.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+func verifyGPGSignature(keyringPath string, o signedObject) (string, error) {
+	keyring, err := ioutil.ReadFile(keyringPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read keyring: %w", err)
+	}
+
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(keyring))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse keyring: %w", err)
+	}
+
+	entity, err := openpgp.CheckArmoredDetachedSignature(entities, bytes.NewReader(o.payload), strings.NewReader(o.signature), nil)
+	if err != nil {
+		return "", err
+	}
+
+	names := make([]string, 0, len(entity.Identities))
+	for name := range entity.Identities {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return fmt.Sprintf("%s (gpg key %s)", strings.Join(names, ", "), entity.PrimaryKey.KeyIdString()), nil
+}
+
+// verifySSHSignature verifies the signature with ssh-keygen, the same way git does, and returns the principal that signed it
+func verifySSHSignature(allowedSigners string, o signedObject) (string, error) {
+	dir, err := ioutil.TempDir(os.TempDir(), "kube-deploy-signature")
+	if err != nil {
+		return "", err
+	}
+
+	defer os.RemoveAll(dir)
+
+	signatureFile := path.Join(dir, "signature")
+
+	err = ioutil.WriteFile(signatureFile, []byte(o.signature), 0600)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := runSSHKeygen(nil, "-Y", "find-principals", "-f", allowedSigners, "-s", signatureFile)
+	if err != nil {
+		return "", fmt.Errorf("signing key is not in the allowed signers file: %w", err)
+	}
+
+	principals := strings.Fields(out)
+	if len(principals) == 0 {
+		return "", errors.New("signing key is not in the allowed signers file")
+	}
+
+	out, err = runSSHKeygen(o.payload, "-Y", "verify", "-f", allowedSigners, "-I", principals[0], "-n", sshSignatureNamespace, "-s", signatureFile)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(out), nil
+}
+
+func runSSHKeygen(stdin []byte, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command("ssh-keygen", args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("ssh-keygen %s failed: %w: %s", args[1], err, strings.TrimSpace(stderr.String()+stdout.String()))
+	}
+
+	return stdout.String(), nil
+}
@@ -0,0 +1,129 @@
+package deploy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// storeObject encodes o into the repo and returns its hash
+func storeObject(t *testing.T, repo *git.Repository, o interface {
+	Encode(plumbing.EncodedObject) error
+}) plumbing.Hash {
+	t.Helper()
+
+	encoded := repo.Storer.NewEncodedObject()
+
+	if err := o.Encode(encoded); err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := repo.Storer.SetEncodedObject(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hash
+}
+
+func TestVerifyConfigSignatureTagMustPointAtCommit(t *testing.T) {
+	entity, err := openpgp.NewEntity("deployer", "", "deployer@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keyring bytes.Buffer
+
+	w, err := armor.Encode(&keyring, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := entity.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+
+	w.Close()
+
+	keyringFile := filepath.Join(t.TempDir(), "keyring.asc")
+	if err := ioutil.WriteFile(keyringFile, keyring.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	author := object.Signature{Name: "t", Email: "t@example.com", When: time.Unix(0, 0)}
+	tree := storeObject(t, repo, &object.Tree{})
+
+	tagged := storeObject(t, repo, &object.Commit{Author: author, Committer: author, Message: "tagged", TreeHash: tree})
+	branch := storeObject(t, repo, &object.Commit{Author: author, Committer: author, Message: "unsigned", TreeHash: tree, ParentHashes: []plumbing.Hash{tagged}})
+
+	tag := &object.Tag{Name: "v1", Tagger: author, Message: "release\n", TargetType: plumbing.CommitObject, Target: tagged}
+
+	unsigned := &plumbing.MemoryObject{}
+	if err := tag.EncodeWithoutSignature(unsigned); err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := readEncodedObject(unsigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, entity, bytes.NewReader(payload), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tag.PGPSignature = signature.String()
+
+	err = repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewTagReferenceName("v1"), storeObject(t, repo, tag)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a branch with the same name as the signed tag is what the ref resolves to
+	err = repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("v1"), branch))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Deploy{ConfigRef: "v1", ConfigKeyring: keyringFile}
+
+	for _, tt := range []struct {
+		name   string
+		commit plumbing.Hash
+		valid  bool
+	}{
+		{name: "tagged commit", commit: tagged, valid: true},
+		{name: "unsigned branch with the tag's name", commit: branch, valid: false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			commit, err := repo.CommitObject(tt.commit)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = d.verifyConfigSignature(repo, commit)
+			if tt.valid && err != nil {
+				t.Errorf("expected the signed tag to verify, got %s", err)
+			}
+
+			if !tt.valid && err == nil {
+				t.Error("expected the unsigned commit to be refused")
+			}
+		})
+	}
+}
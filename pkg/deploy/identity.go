@@ -0,0 +1,92 @@
+package deploy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bcaldwell/kube-deploy/pkg/lib/kubeapi"
+	"github.com/bcaldwell/kube-deploy/pkg/lib/logger"
+	v1 "k8s.io/api/core/v1"
+)
+
+// verifyCluster logs the cluster the kube config points at and refuses to continue if it isn't the expected cluster
+func verifyCluster(kube kubeapi.Client, cluster Cluster) error {
+	info, err := kube.ClusterInfo()
+	if err != nil {
+		return fmt.Errorf("failed to get cluster info for %s: %w", cluster.Name, err)
+	}
+
+	logger.Log("cluster %s is %s", cluster.Name, info)
+
+	expected := cluster.ExpectedCluster
+	if expected == nil {
+		return nil
+	}
+
+	mismatches := []string{}
+
+	if expected.Name != "" && expected.Name != info.Cluster {
+		mismatches = append(mismatches, fmt.Sprintf("name %s != %s", info.Cluster, expected.Name))
+	}
+
+	if expected.Server != "" && strings.TrimSuffix(expected.Server, "/") != strings.TrimSuffix(info.Server, "/") {
+		mismatches = append(mismatches, fmt.Sprintf("server %s != %s", info.Server, expected.Server))
+	}
+
+	if expected.KubeSystemUID != "" {
+		ns := v1.Namespace{}
+
+		err = kube.GetResource("namespace", "", "kube-system", &ns)
+		if err != nil {
+			return fmt.Errorf("failed to verify cluster identity of %s: %w", cluster.Name, err)
+		}
+
+		if string(ns.UID) != expected.KubeSystemUID {
+			mismatches = append(mismatches, fmt.Sprintf("kube-system uid %s != %s", ns.UID, expected.KubeSystemUID))
+		}
+	}
+
+	if c := expected.ConfigMap; c != nil {
+		cm := v1.ConfigMap{}
+
+		err = kube.GetResource("configmap", c.Namespace, c.Name, &cm)
+		if err != nil {
+			return fmt.Errorf("failed to verify cluster identity of %s: %w", cluster.Name, err)
+		}
+
+		if value, ok := cm.Data[c.Key]; !ok || value != c.Value {
+			mismatches = append(mismatches, fmt.Sprintf("configmap %s/%s %s %q != %q", c.Namespace, c.Name, c.Key, value, c.Value))
+		}
+	}
+
+	if len(mismatches) != 0 {
+		return fmt.Errorf("refusing to deploy %s: kube config points at cluster %s but expected %s (%s)",
+			cluster.Name, info, expected, strings.Join(mismatches, ", "))
+	}
+
+	logger.Log("verified identity of cluster %s", cluster.Name)
+
+	return nil
+}
+
+func (i *ClusterIdentity) String() string {
+	parts := []string{}
+
+	if i.Name != "" {
+		parts = append(parts, "name "+i.Name)
+	}
+
+	if i.Server != "" {
+		parts = append(parts, "server "+i.Server)
+	}
+
+	if i.KubeSystemUID != "" {
+		parts = append(parts, "kube-system uid "+i.KubeSystemUID)
+	}
+
+	if c := i.ConfigMap; c != nil {
+		parts = append(parts, fmt.Sprintf("configmap %s/%s %s=%s", c.Namespace, c.Name, c.Key, c.Value))
+	}
+
+	return strings.Join(parts, ", ")
+}
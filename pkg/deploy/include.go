@@ -0,0 +1,150 @@
+package deploy
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/spf13/afero"
+)
+
+// loadMetadata reads a metadata file and merges the files it includes under it.
+// it returns the merged metadata and a config layer for every file in the order they were merged
+func loadMetadata(fs afero.Fs, file string, stack []string) (Metadata, []configLayer, error) {
+	m := Metadata{}
+
+	for _, s := range stack {
+		if s == file {
+			return m, nil, fmt.Errorf("include cycle detected: %s -> %s", strings.Join(stack, " -> "), file)
+		}
+	}
+
+	err := readAndUnmarshal(fs, &m, file)
+	if errors.Is(err, fileNotFoundErr) {
+		return m, nil, fmt.Errorf("%s does not exist", file)
+	}
+
+	if err != nil {
+		return m, nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+
+	raw, err := readRawConfig(fs, file)
+	if err != nil {
+		return m, nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+
+	stack = append(stack, file)
+	merged := Metadata{}
+	layers := []configLayer{}
+
+	for _, include := range m.Include {
+		includeFile := path.Join(path.Dir(file), include)
+
+		included, includedLayers, err := loadMetadata(fs, includeFile, stack)
+		if err != nil {
+			return m, nil, fmt.Errorf("failed to load include %s from %s: %w", include, file, err)
+		}
+
+		merged, err = mergeMetadata(merged, included)
+		if err != nil {
+			return m, nil, fmt.Errorf("failed to merge include %s into %s: %w", include, file, err)
+		}
+
+		layers = append(layers, includedLayers...)
+	}
+
+	layers = append(layers, configLayer{Name: file, Value: m.MetadataConfig, raw: raw})
+
+	if len(m.Include) == 0 {
+		return m, layers, nil
+	}
+
+	merged, err = mergeMetadata(merged, m)
+	if err != nil {
+		return m, nil, fmt.Errorf("failed to merge includes into %s: %w", file, err)
+	}
+
+	merged.Include = nil
+
+	return merged, layers, nil
+}
+
+// readRawConfig reads a config file without a schema so the keys set in it can be told apart from zero values
+func readRawConfig(fs afero.Fs, file string) (map[string]interface{}, error) {
+	content, err := afero.ReadFile(fs, file)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := map[string]interface{}{}
+
+	err = yaml.Unmarshal(content, &raw)
+
+	return raw, err
+}
+
+// rawTarget merges the raw definitions of the target called name from every metadata layer
+func rawTarget(layers []configLayer, name string) map[string]interface{} {
+	raw := Vars{}
+
+	for _, l := range layers {
+		targets, _ := l.raw["targets"].([]interface{})
+
+		for _, t := range targets {
+			target, ok := t.(map[string]interface{})
+			if ok && target["name"] == name {
+				raw = mergeVars(raw, target)
+			}
+		}
+	}
+
+	return map[string]interface{}(raw)
+}
+
+// mergeMetadata merges override over base. targets with the same name are merged, new targets are appended
+func mergeMetadata(base Metadata, override Metadata) (Metadata, error) {
+	merged := Metadata{}
+
+	err := cloneConfig(override, &merged)
+	if err != nil {
+		return merged, err
+	}
+
+	targets := make([]Target, len(base.Targets))
+	copy(targets, base.Targets)
+
+	for _, t := range override.Targets {
+		found := false
+
+		for i := range targets {
+			if targets[i].Name != t.Name {
+				continue
+			}
+
+			targets[i], err = mergeTargets(targets[i], t)
+			if err != nil {
+				return merged, fmt.Errorf("failed to merge target %s: %w", t.Name, err)
+			}
+
+			found = true
+		}
+
+		if !found {
+			targets = append(targets, t)
+		}
+	}
+
+	vars := mergeVars(base.Vars, override.Vars)
+	base.Vars = nil
+	base.Targets = nil
+	merged.Vars = nil
+	merged.Targets = nil
+
+	err = mergeConfig(&merged, base)
+	merged.Vars = vars
+	merged.Targets = targets
+
+	return merged, err
+}
@@ -0,0 +1,160 @@
+package deploy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func writeFiles(t *testing.T, files map[string]string) afero.Fs {
+	t.Helper()
+
+	fs := afero.NewMemMapFs()
+
+	for name, content := range files {
+		if err := afero.WriteFile(fs, name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return fs
+}
+
+func TestLoadMetadataIncludes(t *testing.T) {
+	fs := writeFiles(t, map[string]string{
+		"shared/base.yml": `namespace: base
+kubeContext: base-ctx
+vars: {A: base, B: base, C: base}
+targets:
+- name: prod
+  namespace: base-prod
+  vars: {T: base}
+`,
+		"shared/redis.yml": `include: [base.yml]
+vars: {B: redis, REDIS: redis}
+`,
+		"shared/cache.yml": `vars: {B: cache, C: cache}
+targets:
+- name: prod
+  kubeContext: cache-prod
+- name: staging
+  namespace: staging
+`,
+		"app/metadata.yml": `include: [../shared/redis.yml, ../shared/cache.yml]
+namespace: app
+vars: {C: app}
+targets:
+- name: prod
+  vars: {T: app}
+`,
+	})
+
+	m, layers, err := loadMetadata(fs, "app/metadata.yml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, l := range layers {
+		names = append(names, l.Name)
+	}
+
+	// includes are merged in order, nested includes first, and the file itself last
+	expectedLayers := "shared/base.yml, shared/redis.yml, shared/cache.yml, app/metadata.yml"
+	if strings.Join(names, ", ") != expectedLayers {
+		t.Errorf("expected layers %s, got %s", expectedLayers, strings.Join(names, ", "))
+	}
+
+	if m.Namespace != "app" || m.KubeContext != "base-ctx" {
+		t.Errorf("expected the file to override its includes, got namespace %s and kube context %s", m.Namespace, m.KubeContext)
+	}
+
+	for name, expected := range map[string]string{"A": "base", "B": "cache", "C": "app", "REDIS": "redis"} {
+		if m.Vars[name] != expected {
+			t.Errorf("expected var %s to be %q, got %q", name, expected, m.Vars[name])
+		}
+	}
+
+	if len(m.Include) != 0 {
+		t.Errorf("expected includes to be cleared after merging, got %v", m.Include)
+	}
+
+	targets := make(map[string]Target)
+	for _, target := range m.Targets {
+		targets[target.Name] = target
+	}
+
+	if len(targets) != 2 {
+		t.Fatalf("expected targets with the same name to be merged, got %d targets", len(m.Targets))
+	}
+
+	prod := targets["prod"]
+	if prod.Namespace != "base-prod" || prod.KubeContext != "cache-prod" || prod.Vars["T"] != "app" {
+		t.Errorf("expected prod to be merged from every file, got %+v", prod)
+	}
+
+	if targets["staging"].Namespace != "staging" {
+		t.Errorf("expected staging to be added, got %+v", targets["staging"])
+	}
+}
+
+func TestLoadMetadataIncludeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		err   string
+	}{
+		{
+			name: "cycle",
+			files: map[string]string{
+				"app/metadata.yml": "include: [a.yml]\n",
+				"app/a.yml":        "include: [b.yml]\n",
+				"app/b.yml":        "include: [a.yml]\n",
+			},
+			err: "include cycle detected: app/metadata.yml -> app/a.yml -> app/b.yml -> app/a.yml",
+		},
+		{
+			name: "self include",
+			files: map[string]string{
+				"app/metadata.yml": "include: [metadata.yml]\n",
+			},
+			err: "include cycle detected: app/metadata.yml -> app/metadata.yml",
+		},
+		{
+			name: "missing include",
+			files: map[string]string{
+				"app/metadata.yml": "include: [missing.yml]\n",
+			},
+			err: "app/missing.yml does not exist",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := loadMetadata(writeFiles(t, tt.files), "app/metadata.yml", nil)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestLoadMetadataDiamondIncludes(t *testing.T) {
+	// a file included through two paths isn't a cycle
+	fs := writeFiles(t, map[string]string{
+		"app/metadata.yml": "include: [a.yml, b.yml]\n",
+		"app/a.yml":        "include: [common.yml]\n",
+		"app/b.yml":        "include: [common.yml]\n",
+		"app/common.yml":   "namespace: common\n",
+	})
+
+	m, _, err := loadMetadata(fs, "app/metadata.yml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Namespace != "common" {
+		t.Errorf("expected namespace common, got %s", m.Namespace)
+	}
+}
@@ -10,10 +10,15 @@ import (
 	"github.com/bcaldwell/kube-deploy/pkg/lib/logger"
 )
 
-const inClusterSAMountPoint = "/var/run/secrets/kubernetes.io/serviceaccount"
+const (
+	inClusterSAMountPoint = "/var/run/secrets/kubernetes.io/serviceaccount"
+	tempKubeconfigPrefix  = "kube-deploy-kubeconfig"
+)
 
-func (d *Deploy) getKubeConfig() (string, error) {
-	if kubeconfig := os.Getenv("KUBE_CONFIG"); kubeconfig != "" {
+// getKubeConfig returns the path to the kube config for the cluster, it is a temp file when the config comes from an env variable.
+// KUBE_CONFIG is only respected when useKubeConfigEnv is set so it can't send every cluster in a multi-cluster deploy to the same place
+func (c Cluster) getKubeConfig(useKubeConfigEnv bool) (string, error) {
+	if kubeconfig := os.Getenv("KUBE_CONFIG"); kubeconfig != "" && useKubeConfigEnv {
 		kubeconfig = expandPath(kubeconfig)
 
 		if _, err := os.Stat(kubeconfig); err == nil {
@@ -22,22 +27,22 @@ func (d *Deploy) getKubeConfig() (string, error) {
 		}
 	}
 
-	if _, err := os.Stat(d.KubeconfigPath); err == nil {
-		logger.Log("Using existing kube config found at %s", d.KubeconfigPath)
-		return d.KubeconfigPath, nil
+	if _, err := os.Stat(c.KubeconfigPath); err == nil {
+		logger.Log("Using existing kube config found at %s", c.KubeconfigPath)
+		return c.KubeconfigPath, nil
 	}
 
-	if kubeconfig := os.Getenv(d.KubeconfigEnv); kubeconfig != "" {
+	if kubeconfig := os.Getenv(c.KubeconfigEnv); kubeconfig != "" {
 		var err error
 
-		logger.Log("creating kubeconfig from environment variable %s", d.KubeconfigEnv)
+		logger.Log("creating kubeconfig from environment variable %s", c.KubeconfigEnv)
 
 		configBytes, err := base64.StdEncoding.DecodeString(kubeconfig)
 		if err != nil {
 			return "", fmt.Errorf("Failed to decode base64 encode kube config %w", err)
 		}
 
-		f, err := ioutil.TempFile(os.TempDir(), "kube-deploy-kubeconfig")
+		f, err := ioutil.TempFile(os.TempDir(), tempKubeconfigPrefix)
 		if err != nil {
 			return "", fmt.Errorf("failed to create temp file for downloaded kube config: %w", err)
 		}
@@ -0,0 +1,159 @@
+package deploy
+
+import (
+	"fmt"
+
+	"github.com/bcaldwell/kube-deploy/pkg/lib/kubeapi"
+	"github.com/bcaldwell/kube-deploy/pkg/lib/logger"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	managedByLabel = "app.kubernetes.io/managed-by"
+	// name used for the resource quota and limit range created in the deploy namespace
+	namespacePolicyName = "kube-deploy"
+)
+
+type NamespaceConfig struct {
+	Labels      map[string]string
+	Annotations map[string]string
+	// applied as a ResourceQuota named kube-deploy
+	ResourceQuota *v1.ResourceQuotaSpec
+	// applied as a LimitRange named kube-deploy
+	LimitRange *v1.LimitRangeSpec
+}
+
+// createNamespaces creates the namespaces the deploy touches and applies the resource quota and limit range to the deploy namespace.
+// the quota and limit range are applied even when createNamespace is false so namespaces created by someone else still get them
+func (d *Deploy) createNamespaces() error {
+	if d.CreateNamespace != nil && !*d.CreateNamespace {
+		logger.Log("skipping creating namespaces, createNamespace is false")
+	} else {
+		for _, namespace := range d.namespaces() {
+			config := NamespaceConfig{}
+
+			// namespace config only applies to the deploy namespace, other namespaces like monitoring are shared
+			if namespace == d.Namespace && d.NamespaceConfig != nil {
+				config = *d.NamespaceConfig
+			}
+
+			err := createNamespace(d.kube, namespace, config)
+			if err != nil {
+				return fmt.Errorf("error while creating namespace %s %w", namespace, err)
+			}
+		}
+	}
+
+	if d.Namespace == "" || d.NamespaceConfig == nil {
+		return nil
+	}
+
+	err := applyNamespacePolicies(d.kube, d.Namespace, *d.NamespaceConfig)
+	if err != nil {
+		return fmt.Errorf("error while configuring namespace %s %w", d.Namespace, err)
+	}
+
+	return nil
+}
+
+// folderNamespace returns the namespace a folder deploys to, a helm chart namespace wins over the folder namespace
+func (d *Deploy) folderNamespace(folder DeployFolder, renderEngine RenderEngine) string {
+	if renderEngine == RenderEngineHelm && folder.HelmChart != nil && folder.HelmChart.Namespace != "" {
+		return folder.HelmChart.Namespace
+	}
+
+	if folder.Namespace != "" {
+		return folder.Namespace
+	}
+
+	return d.Namespace
+}
+
+// namespaces returns every namespace the deploy touches so they can be created up front
+func (d *Deploy) namespaces() []string {
+	namespaces := []string{}
+
+	if d.Namespace != "" {
+		namespaces = append(namespaces, d.Namespace)
+	}
+
+	for _, folder := range d.DeployFolders {
+		namespace := d.folderNamespace(folder, getRenderEngineWithDefault(d.fs, folder))
+		if namespace != "" {
+			namespaces = appendUnique(namespaces, namespace)
+		}
+	}
+
+	return namespaces
+}
+
+func createNamespace(kube kubeapi.Client, namespace string, config NamespaceConfig) error {
+	labels := map[string]string{}
+	for k, v := range config.Labels {
+		labels[k] = v
+	}
+
+	labels[managedByLabel] = "kube-deploy"
+
+	ns := v1.Namespace{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Namespace",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        namespace,
+			Labels:      labels,
+			Annotations: config.Annotations,
+		},
+	}
+
+	logger.Log("creating namespace %s", namespace)
+
+	return kube.ApplyResource(ns)
+}
+
+// applyNamespacePolicies applies the resource quota and limit range from the namespace config
+func applyNamespacePolicies(kube kubeapi.Client, namespace string, config NamespaceConfig) error {
+	if config.ResourceQuota != nil {
+		logger.Log("creating resource quota %s in %s", namespacePolicyName, namespace)
+
+		err := kube.ApplyResource(v1.ResourceQuota{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "ResourceQuota",
+				APIVersion: "v1",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      namespacePolicyName,
+				Namespace: namespace,
+				Labels:    map[string]string{managedByLabel: "kube-deploy"},
+			},
+			Spec: *config.ResourceQuota,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply resource quota: %w", err)
+		}
+	}
+
+	if config.LimitRange != nil {
+		logger.Log("creating limit range %s in %s", namespacePolicyName, namespace)
+
+		err := kube.ApplyResource(v1.LimitRange{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "LimitRange",
+				APIVersion: "v1",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      namespacePolicyName,
+				Namespace: namespace,
+				Labels:    map[string]string{managedByLabel: "kube-deploy"},
+			},
+			Spec: *config.LimitRange,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply limit range: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package deploy
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bcaldwell/kube-deploy/pkg/lib/logger"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	appLabel        = "kube-deploy.io/app"
+	folderLabel     = "kube-deploy.io/folder"
+	targetLabel     = "kube-deploy.io/target"
+	namespaceLabel  = "kube-deploy.io/namespace"
+	sourceHashLabel = "kube-deploy.io/source-hash"
+	// path of the file the secret was created from, only informational
+	sourceFileAnnotation = "kube-deploy.io/source-file"
+	// set to "false" to keep a secret after its source file is removed
+	pruneAnnotation = "kube-deploy.io/prune"
+
+	maxLabelValueLength = 63
+)
+
+var invalidLabelValueChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// secretOwner identifies the config folder, deploy folder, target, namespace and file a secret was created from.
+// the target and namespace keep deploys of the same folder to one cluster from pruning each other's secrets
+type secretOwner struct {
+	app       string
+	folder    string
+	target    string
+	namespace string
+	file      string
+	hash      string
+}
+
+func newSecretOwner(c context, folder DeployFolder, namespace string, file string, content []byte) *secretOwner {
+	return &secretOwner{
+		app:       c.app,
+		folder:    labelValue(folder.Path),
+		target:    c.target,
+		namespace: labelValue(namespace),
+		file:      file,
+		hash:      fmt.Sprintf("%x", sha256.Sum256(content))[:16],
+	}
+}
+
+func (o *secretOwner) selector() string {
+	return fmt.Sprintf("%s=%s,%s=%s,%s=%s,%s=%s", appLabel, o.app, folderLabel, o.folder, targetLabel, o.target, namespaceLabel, o.namespace)
+}
+
+func (o *secretOwner) label(secret *v1.Secret) {
+	secret.Labels[appLabel] = o.app
+	secret.Labels[folderLabel] = o.folder
+	secret.Labels[targetLabel] = o.target
+	secret.Labels[namespaceLabel] = o.namespace
+	secret.Labels[sourceHashLabel] = o.hash
+
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+
+	secret.Annotations[sourceFileAnnotation] = o.file
+}
+
+// labelValue makes s a valid label value. values that are too long are shortened and suffixed with a hash to keep them unique
+func labelValue(s string) string {
+	value := strings.Trim(invalidLabelValueChars.ReplaceAllString(s, "-"), "-_.")
+
+	if len(value) > maxLabelValueLength {
+		hash := fmt.Sprintf("%x", sha256.Sum256([]byte(s)))[:10]
+		value = strings.Trim(value[:maxLabelValueLength-len(hash)-1], "-_.") + "-" + hash
+	}
+
+	return value
+}
+
+// secretOutputKind is the kind pruned for secret files, it matches what the secret output creates
+func (d *Deploy) secretOutputKind() string {
+	if d.SecretOutput != nil {
+		switch d.SecretOutput.Mode {
+		case SecretOutputModeSealedSecret:
+			return "sealedsecrets.bitnami.com"
+		case SecretOutputModeExternalSecret:
+			return "externalsecrets.external-secrets.io"
+		}
+	}
+
+	return "secrets"
+}
+
+type ownedResourceList struct {
+	Items []metav1.PartialObjectMetadata `json:"items"`
+}
+
+// deployedSecrets are the secrets created from secret files in a folder and the files they came from.
+// files that were skipped are included so the secrets they created before are kept
+type deployedSecrets struct {
+	secrets map[string]bool
+	files   map[string]bool
+}
+
+func newDeployedSecrets() deployedSecrets {
+	return deployedSecrets{
+		secrets: make(map[string]bool),
+		files:   make(map[string]bool),
+	}
+}
+
+// pruneSecrets deletes the secrets created from files in the folder that weren't deployed this time because their file was removed.
+// only secrets owned by this target and namespace are pruned, and only in the namespaces the deploy touches.
+// secrets annotated with kube-deploy.io/prune: "false" are kept, in dry run mode the secrets that would be deleted are only logged
+func pruneSecrets(c context, folder DeployFolder, namespace string, deployed deployedSecrets) error {
+	selector := newSecretOwner(c, folder, namespace, "", nil).selector()
+
+	namespaces := appendUnique(append([]string{}, c.deployNamespaces...), namespace)
+	for key := range deployed.secrets {
+		namespaces = appendUnique(namespaces, strings.SplitN(key, "/", 2)[0])
+	}
+
+	owned := ownedResourceList{}
+
+	for _, ns := range namespaces {
+		if ns == "" {
+			continue
+		}
+
+		list := ownedResourceList{}
+
+		err := c.kube.ListResources(c.secretKind, ns, selector, &list)
+		if err != nil {
+			// listing needs more permissions than deploying, don't fail deploys that worked before pruning existed
+			logger.Log("skipping pruning secrets for folder %s: %s", folder.Path, err)
+			return nil
+		}
+
+		owned.Items = append(owned.Items, list.Items...)
+	}
+
+	sort.Slice(owned.Items, func(i, j int) bool {
+		return owned.Items[i].Namespace+"/"+owned.Items[i].Name < owned.Items[j].Namespace+"/"+owned.Items[j].Name
+	})
+
+	for _, item := range owned.Items {
+		key := item.Namespace + "/" + item.Name
+		source := item.Annotations[sourceFileAnnotation]
+
+		switch {
+		case deployed.secrets[key], deployed.files[source]:
+			continue
+		case item.Annotations[pruneAnnotation] == "false":
+			logger.Log("keeping %s %s from removed file %s, %s is false", c.secretKind, key, source, pruneAnnotation)
+			continue
+		case len(item.OwnerReferences) != 0:
+			// secrets created by a controller, like a sealed secret, are removed with their owner
+			continue
+		case c.kube.DryRun:
+			logger.Log("would prune %s %s from removed file %s", c.secretKind, key, source)
+			continue
+		}
+
+		logger.Log("pruning %s %s from removed file %s", c.secretKind, key, source)
+
+		err := c.kube.DeleteResource(c.secretKind, item.Namespace, item.Name)
+		if err != nil {
+			return fmt.Errorf("failed to prune %s %s: %w", c.secretKind, key, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,380 @@
+package deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/bcaldwell/kube-deploy/pkg/lib/ejsonsecret"
+	"github.com/bcaldwell/kube-deploy/pkg/lib/kubeapi"
+	"github.com/bcaldwell/kube-deploy/pkg/lib/logger"
+	"github.com/bcaldwell/kube-deploy/pkg/lib/sealedsecret"
+	"github.com/bcaldwell/kube-deploy/pkg/lib/secretprovider"
+	"github.com/bcaldwell/kube-deploy/pkg/lib/sopssecret"
+	"github.com/bcaldwell/kube-deploy/pkg/lib/vaultsecret"
+	"github.com/spf13/afero"
+	v1 "k8s.io/api/core/v1"
+)
+
+const secretProviderLabel = "kube-deploy.io/secret-provider"
+
+func (d *Deploy) secretRegistry() (*secretprovider.Registry, error) {
+	ejsonProvider, err := ejsonsecret.NewProvider(d.EjsonKeyDir)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := secretprovider.NewRegistry()
+	registry.RegisterExtension(".ejson", ejsonProvider)
+	registry.Register(sopssecret.Provider{})
+	registry.Register(secretprovider.Files{})
+
+	vaultConfig := vaultsecret.Config{}
+	if d.Vault != nil {
+		vaultConfig = *d.Vault
+	}
+
+	if vaultConfig.Auth.ServiceAccountTokenPath == "" {
+		vaultConfig.Auth.ServiceAccountTokenPath = path.Join(inClusterSAMountPoint, "token")
+	}
+
+	registry.Register(vaultsecret.New(vaultConfig))
+
+	return registry, nil
+}
+
+// deployAndDeleteSecretFiles deploys every secret file in the folder and removes them so kubectl doesn't apply them.
+// when secrets are converted to another kind the converted manifests are written next to the file, e.g. db.ejson
+// becomes db.sealedsecret.json, and are applied with the rest of the folder. kustomize only applies the resources
+// listed in kustomization.yaml, so converted manifests in kustomize folders are applied directly instead.
+// the secrets created from the files and the files are returned so secrets from removed files can be pruned
+func deployAndDeleteSecretFiles(c context, namespace string, folder DeployFolder) (deployedSecrets, error) {
+	deployed := newDeployedSecrets()
+
+	fileList, err := listAllFilesInFolder(c.fs, folder.Path)
+	if err != nil {
+		return deployed, err
+	}
+
+	writeConverted := c.convertSecret != nil && getRenderEngineWithDefault(c.fs, folder) != RenderEngineKustomize
+
+	for _, file := range fileList {
+		content, err := afero.ReadFile(c.fs, file)
+		if err != nil {
+			return deployed, err
+		}
+
+		provider := c.secrets.ForFile(file, content)
+		if provider == nil {
+			continue
+		}
+
+		source := secretprovider.Source{
+			Path:      path.Join(c.rootDir, file),
+			Content:   content,
+			Dir:       path.Join(c.rootDir, path.Dir(file)),
+			Namespace: namespace,
+		}
+
+		var secrets []interface{}
+
+		decrypted, manifests, err := secretprovider.Decrypt(provider, source)
+		if err == nil {
+			secrets, err = secretManifests(c, provider.Name(), decrypted, newSecretOwner(c, folder, namespace, file, content))
+		}
+
+		if errors.Is(err, secretprovider.ErrInvalidSecret) {
+			logger.Log("skipping creating %s secret from %s: %s", provider.Name(), file, err)
+
+			// the file still exists so the secrets it created before must not be pruned
+			deployed.files[file] = true
+
+			continue
+		}
+
+		if err != nil {
+			return deployed, err
+		}
+
+		if writeConverted {
+			err = writeSecretManifests(c.fs, file, secrets)
+		} else {
+			err = applySecretManifests(c.kube, secrets)
+		}
+
+		if err != nil {
+			return deployed, err
+		}
+
+		err = applyDecryptedManifests(c, provider.Name(), manifests)
+		if err != nil {
+			return deployed, err
+		}
+
+		deployed.files[file] = true
+
+		for _, secret := range secrets {
+			deployed.secrets[manifestKey(secret)] = true
+		}
+
+		// remove secret file because it will cause issues with kubectl
+		err = c.fs.Remove(file)
+		if err != nil {
+			return deployed, err
+		}
+	}
+
+	return deployed, nil
+}
+
+// deployDeclaredSecrets deploys the secrets declared in metadata.yml
+func (d *Deploy) deployDeclaredSecrets() error {
+	if len(d.Secrets) == 0 {
+		return nil
+	}
+
+	for _, declaration := range d.Secrets {
+		provider, err := d.secrets.ForName(declaration.Provider)
+		if err != nil {
+			return fmt.Errorf("secret %s: %w", declaration.Name, err)
+		}
+
+		config, err := json.Marshal(declaration.Config)
+		if err != nil {
+			return err
+		}
+
+		namespace := declaration.Namespace
+		if namespace == "" {
+			namespace = d.Namespace
+		}
+
+		err = deploySecretSource(d.context, provider, secretprovider.Source{
+			Dir:       path.Join(d.rootDir, d.ConfigFolder),
+			Namespace: namespace,
+			Name:      declaration.Name,
+			Config:    config,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to deploy secret %s from %s: %w", declaration.Name, declaration.Provider, err)
+		}
+	}
+
+	return nil
+}
+
+// deploySecretSource gets the secrets from the provider, labels them and applies them
+func deploySecretSource(c context, provider secretprovider.SecretProvider, source secretprovider.Source) error {
+	decrypted, manifests, err := secretprovider.Decrypt(provider, source)
+	if err != nil {
+		return err
+	}
+
+	secrets, err := secretManifests(c, provider.Name(), decrypted, nil)
+	if err != nil {
+		return err
+	}
+
+	err = applySecretManifests(c.kube, secrets)
+	if err != nil {
+		return err
+	}
+
+	return applyDecryptedManifests(c, provider.Name(), manifests)
+}
+
+func applySecretManifests(kube kubeapi.Client, secrets []interface{}) error {
+	for _, secret := range secrets {
+		err := kube.ApplyResource(secret)
+		if err != nil {
+			return fmt.Errorf("failed to apply secret %s: %w", manifestKey(secret), err)
+		}
+	}
+
+	return nil
+}
+
+// writeSecretManifests writes the converted secrets from a secret file into the folder so they are applied with it.
+// decrypted manifests that aren't secrets must not be written to disk so they are still applied directly
+func writeSecretManifests(fs afero.Fs, file string, secrets []interface{}) error {
+	if len(secrets) == 0 {
+		return nil
+	}
+
+	var manifest interface{} = secrets[0]
+	if len(secrets) > 1 {
+		manifest = map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "List",
+			"items":      secrets,
+		}
+	}
+
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	kind := strings.ToLower(fmt.Sprint(secrets[0].(map[string]interface{})["kind"]))
+	out := strings.TrimSuffix(file, path.Ext(file)) + "." + kind + ".json"
+
+	err = afero.WriteFile(fs, out, b, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write converted secrets from %s: %w", file, err)
+	}
+
+	return nil
+}
+
+// applyDecryptedManifests applies the manifests that aren't secrets from providers that decrypt them
+func applyDecryptedManifests(c context, providerName string, manifests []map[string]interface{}) error {
+	for _, manifest := range manifests {
+		logger.Log("applying decrypted %s from %s", manifest["kind"], providerName)
+
+		err := c.kube.ApplyResource(manifest)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// secretManifests labels the decrypted secrets and converts them to the configured secret output.
+// owner is nil for secrets that don't come from a file
+func secretManifests(c context, providerName string, secrets []v1.Secret, owner *secretOwner) ([]interface{}, error) {
+	manifests := make([]interface{}, 0, len(secrets))
+
+	for _, secret := range secrets {
+		if secret.Namespace == "" {
+			return nil, fmt.Errorf("%w: namespace can not be blank for secret %s", secretprovider.ErrInvalidSecret, secret.Name)
+		}
+
+		if secret.Labels == nil {
+			secret.Labels = make(map[string]string)
+		}
+
+		err := secretprovider.ValidateSecret(secret)
+		if err != nil {
+			return nil, err
+		}
+
+		secret.Labels[managedByLabel] = "kube-deploy"
+		secret.Labels[secretProviderLabel] = providerName
+
+		if owner != nil {
+			owner.label(&secret)
+		}
+
+		c.recordSecretHash(secret)
+
+		if c.convertSecret == nil {
+			logger.Log("creating secret %s in %s from %s with keys %s", secret.Name, secret.Namespace, providerName, redactedSecretKeys(secret))
+
+			manifests = append(manifests, secret)
+
+			continue
+		}
+
+		manifest, err := c.convertSecret(secret)
+		if err != nil {
+			return nil, err
+		}
+
+		logger.Log("creating %s %s in %s from %s with keys %s", manifest["kind"], secret.Name, secret.Namespace, providerName, redactedSecretKeys(secret))
+
+		manifests = append(manifests, manifest)
+	}
+
+	return manifests, nil
+}
+
+// manifestKey returns namespace/name of a secret or converted secret manifest
+func manifestKey(manifest interface{}) string {
+	switch m := manifest.(type) {
+	case v1.Secret:
+		return m.Namespace + "/" + m.Name
+	case map[string]interface{}:
+		if metadata, ok := m["metadata"].(map[string]interface{}); ok {
+			return fmt.Sprintf("%s/%s", metadata["namespace"], metadata["name"])
+		}
+	}
+
+	return ""
+}
+
+// secretConverter converts a decrypted secret into the manifest that is deployed instead of it
+type secretConverter func(secret v1.Secret) (map[string]interface{}, error)
+
+// secretConverter returns the converter for the configured secret output, nil when secrets are applied directly
+func (d *Deploy) secretConverter() (secretConverter, error) {
+	if d.SecretOutput == nil {
+		return nil, nil
+	}
+
+	switch d.SecretOutput.Mode {
+	case SecretOutputModeSecret:
+		return nil, nil
+	case SecretOutputModeSealedSecret:
+		if d.SecretOutput.SealedSecretsCert == "" {
+			return nil, errors.New("secretOutput.sealedSecretsCert is required to create sealed secrets")
+		}
+
+		// vars are expanded so clusters can use their own cert
+		certPath := os.Expand(d.SecretOutput.SealedSecretsCert, d.expandVar)
+
+		cert, err := d.readConfigFile(certPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sealed secrets cert: %w", err)
+		}
+
+		sealer, err := sealedsecret.NewSealer(cert, d.SecretOutput.SealedSecretsScope)
+		if err != nil {
+			return nil, err
+		}
+
+		return sealer.Seal, nil
+	case SecretOutputModeExternalSecret:
+		store := d.SecretOutput.ExternalSecretStore
+		if store == nil || store.Name == "" {
+			return nil, errors.New("secretOutput.externalSecretStore.name is required to create external secrets")
+		}
+
+		return func(secret v1.Secret) (map[string]interface{}, error) {
+			return store.Manifest(secret), nil
+		}, nil
+	}
+
+	return nil, fmt.Errorf("invalid secret output mode %s", d.SecretOutput.Mode)
+}
+
+// readConfigFile reads a file relative to the config folder, absolute paths are read from disk
+func (d *Deploy) readConfigFile(file string) ([]byte, error) {
+	if path.IsAbs(file) {
+		return ioutil.ReadFile(file)
+	}
+
+	return afero.ReadFile(d.srcFs, path.Join(d.ConfigFolder, file))
+}
+
+// redactedSecretKeys lists the keys of a secret without their values so secrets can be logged
+func redactedSecretKeys(secret v1.Secret) string {
+	keys := make([]string, 0, len(secret.Data)+len(secret.StringData))
+
+	for k := range secret.Data {
+		keys = append(keys, k+"=<redacted>")
+	}
+
+	for k := range secret.StringData {
+		keys = append(keys, k+"=<redacted>")
+	}
+
+	sort.Strings(keys)
+
+	return strings.Join(keys, ", ")
+}
@@ -0,0 +1,121 @@
+package deploy
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/bcaldwell/kube-deploy/pkg/lib/secretprovider"
+	"github.com/spf13/afero"
+	v1 "k8s.io/api/core/v1"
+)
+
+func memoryContext(t *testing.T, memory secretprovider.Memory, files map[string]string) context {
+	t.Helper()
+
+	fs := afero.NewMemMapFs()
+
+	for name, content := range files {
+		if err := afero.WriteFile(fs, name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	registry := secretprovider.NewRegistry()
+	registry.RegisterExtension(".mem", memory)
+
+	return context{
+		fs:           fs,
+		secrets:      registry,
+		app:          "app",
+		target:       "prod",
+		configHashes: make(map[string]string),
+		// converting the secrets writes them into the folder, so nothing is applied to a cluster
+		convertSecret: func(secret v1.Secret) (map[string]interface{}, error) {
+			return map[string]interface{}{
+				"kind":     "SealedSecret",
+				"metadata": map[string]interface{}{"name": secret.Name, "namespace": secret.Namespace},
+				"labels":   secret.Labels,
+			}, nil
+		},
+	}
+}
+
+func TestDeployAndDeleteSecretFiles(t *testing.T) {
+	memory := secretprovider.Memory{
+		Sources: map[string][]v1.Secret{
+			"app/secrets/db.mem": {secretprovider.NewSecret("db", "")},
+		},
+		Errors: map[string]error{
+			"app/secrets/bad.mem": fmt.Errorf("%w: _name can not be blank", secretprovider.ErrInvalidSecret),
+		},
+	}
+
+	c := memoryContext(t, memory, map[string]string{
+		"app/secrets/db.mem":  "encrypted",
+		"app/secrets/bad.mem": "encrypted",
+		"app/secrets/cm.yaml": "kind: ConfigMap",
+	})
+
+	deployed, err := deployAndDeleteSecretFiles(c, "web", DeployFolder{Path: "app/secrets"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !deployed.secrets["web/db"] {
+		t.Errorf("expected web/db to be deployed, got %v", deployed.secrets)
+	}
+
+	for _, file := range []string{"app/secrets/db.mem", "app/secrets/bad.mem"} {
+		if !deployed.files[file] {
+			t.Errorf("expected %s to be kept from pruning, got %v", file, deployed.files)
+		}
+	}
+
+	if exists, _ := afero.Exists(c.fs, "app/secrets/db.mem"); exists {
+		t.Error("expected the secret file to be removed")
+	}
+
+	if exists, _ := afero.Exists(c.fs, "app/secrets/bad.mem"); !exists {
+		t.Error("expected the invalid secret file to be skipped")
+	}
+
+	b, err := afero.ReadFile(c.fs, "app/secrets/db.sealedsecret.json")
+	if err != nil {
+		t.Fatalf("expected the converted secret to be written: %s", err)
+	}
+
+	manifest := struct {
+		Labels map[string]string
+	}{}
+
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	for label, expected := range map[string]string{
+		managedByLabel:      "kube-deploy",
+		secretProviderLabel: "memory",
+		appLabel:            "app",
+		folderLabel:         "app-secrets",
+		targetLabel:         "prod",
+		namespaceLabel:      "web",
+	} {
+		if manifest.Labels[label] != expected {
+			t.Errorf("expected label %s to be %q, got %q", label, expected, manifest.Labels[label])
+		}
+	}
+
+	if _, ok := c.configHashes[configKey("Secret", "web", "db")]; !ok {
+		t.Error("expected the secret hash to be recorded for config checksums")
+	}
+}
+
+func TestSecretManifestsRequiresNamespace(t *testing.T) {
+	c := memoryContext(t, secretprovider.Memory{}, nil)
+
+	_, err := secretManifests(c, "memory", []v1.Secret{secretprovider.NewSecret("db", "")}, nil)
+	if err == nil {
+		t.Error("expected an error for a secret without a namespace")
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -15,18 +14,13 @@ import (
 	"time"
 
 	"github.com/bcaldwell/kube-deploy/pkg/lib/logger"
-	"github.com/go-git/go-billy/v5"
-	"github.com/go-git/go-billy/v5/memfs"
-	"github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/plumbing/transport"
-	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
-	"github.com/go-git/go-git/v5/storage/memory"
 	"github.com/spf13/afero"
 )
 
 var errNoGitDirFound = errors.New("no git repository found")
 
-func (d *Deploy) Run(target string) error {
+// Configure loads the config folder and applies the config for target without rendering or deploying anything
+func (d *Deploy) Configure(target string) error {
 	var err error
 	// returns a FS were the config folder is the root
 	d.srcFs, err = d.setupFS()
@@ -34,7 +28,11 @@ func (d *Deploy) Run(target string) error {
 		return err
 	}
 
-	err = d.ConfigureFolderFromMetadata(d.ConfigFolder, target)
+	return d.ConfigureFolderFromMetadata(d.ConfigFolder, target)
+}
+
+func (d *Deploy) Run(target string) error {
+	err := d.Configure(target)
 	if err != nil {
 		return err
 	}
@@ -42,39 +40,33 @@ func (d *Deploy) Run(target string) error {
 	b, _ := json.Marshal(d)
 	logger.Log("using config %s", b)
 
-	kubeConfig, err := d.getKubeConfig()
-	if err != nil {
-		return err
+	if d.ConfigCommit != "" {
+		logger.Log("deploying config commit %s", d.ConfigCommit)
 	}
 
-	// remove the kubeconfig if it is a temp file
-	if strings.HasPrefix(kubeConfig, os.TempDir()) {
-		defer os.Remove(kubeConfig)
-	}
-
-	d.setEnv(kubeConfig)
+	d.setEnv()
 
 	d.rootDir, err = ioutil.TempDir(os.TempDir(), "kube-deploy")
 	if err != nil {
 		return err
 	}
 
-	d.fs = afero.NewBasePathFs(afero.NewOsFs(), d.rootDir)
+	defer os.RemoveAll(d.rootDir)
 
-	err = copyAndProcessFolder(d.srcFs, d.ConfigFolder, d.fs, "/", func(_, src string) string {
-		return os.Expand(src, expandEnvSafe)
-	})
+	d.fs, err = d.render("rendered")
 	if err != nil {
 		return err
 	}
 
-	if d.Bastion != nil {
-		sshTun := d.setupPortForward(d.Bastion.Host, 6443, d.Bastion.RemotePortforwardHost, 6443)
-		if sshTun != nil {
-			defer sshTun.Stop()
-		}
+	d.secrets, err = d.secretRegistry()
+	if err != nil {
+		return err
 	}
 
+	d.app = labelValue(d.ConfigFolder)
+	d.target = labelValue(target)
+	d.secretKind = d.secretOutputKind()
+
 	// sort deploy folder by priority
 	sort.Slice(d.DeployFolders, func(i, j int) bool {
 		var a, b int
@@ -89,40 +81,29 @@ func (d *Deploy) Run(target string) error {
 		return a < b
 	})
 
-	return d.runDeploy()
+	return d.deployClusters()
+}
+
+// render copies the config folder into dir inside of rootDir, expanding vars and rendering templates
+func (d *Deploy) render(dir string) (afero.Fs, error) {
+	fs := afero.NewBasePathFs(afero.NewOsFs(), path.Join(d.rootDir, dir))
+
+	err := copyAndProcessFolder(d.srcFs, d.ConfigFolder, fs, "/", d.processFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs, renameRenderedTemplates(fs, d.ConfigFolder)
 }
 
-// setupFS returns a FS were the config folder is the root
+// setupFS returns a FS rooted at the root of the config repo, the config folder is relative to it
 // it will clone the config repo if needed
 func (d *Deploy) setupFS() (afero.Fs, error) {
 	// clone repo if repo is provided
 	if d.ConfigRepo != "" {
 		logger.Log("Cloning config repo %s", d.ConfigRepo)
 
-		mfs := memfs.New()
-
-		auth, err := getGitAuth()
-		if err != nil {
-			return nil, err
-		}
-
-		_, err = git.Clone(memory.NewStorage(), mfs, &git.CloneOptions{
-			URL:  d.ConfigRepo,
-			Auth: auth,
-		})
-		if err != nil {
-			return nil, err
-		}
-
-		if p, err := mfs.Stat(d.ConfigFolder); err != nil || !p.IsDir() {
-			return nil, fmt.Errorf("config folder either doesnt exist or is not a directory: %s", d.ConfigFolder)
-		}
-
-		fs := afero.NewMemMapFs()
-
-		err = convertBillyToAfero(mfs, d.ConfigFolder, fs, "/")
-
-		return fs, err
+		return d.cloneConfigRepo()
 	}
 
 	if p, err := os.Stat(d.ConfigFolder); err != nil || !p.IsDir() {
@@ -138,6 +119,25 @@ func (d *Deploy) setupFS() (afero.Fs, error) {
 		return nil, err
 	}
 
+	gitRoot, err = filepath.Abs(gitRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	configFolder, err := filepath.Abs(d.ConfigFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	// the config folder is used as a path inside of the fs so it has to be relative to the git root
+	d.ConfigFolder, err = filepath.Rel(gitRoot, configFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	d.localDir = gitRoot
+	d.ConfigCommit = localCommit(gitRoot)
+
 	return afero.NewBasePathFs(afero.NewOsFs(), gitRoot), nil
 }
 
@@ -161,17 +161,11 @@ func findTopLevelGitDir(workingDir string) (string, error) {
 	}
 }
 
-func getGitAuth() (transport.AuthMethod, error) {
-	// https://github.com/src-d/go-git/issues/637
-	return ssh.NewSSHAgentAuth("git")
-}
-
-func (d *Deploy) setEnv(kubeconfig string) {
+func (d *Deploy) setEnv() {
 	for k, v := range d.Vars {
-		os.Setenv(k, v)
+		os.Setenv(k, varToString(v))
 	}
 
-	os.Setenv("KUBECONFIG", kubeconfig)
 	os.Setenv("NAMESPACE", d.Namespace)
 }
 
@@ -208,63 +202,26 @@ func timedRun(name string, function Run) error {
 	return err
 }
 
-func filterString(vs []string, f func(string) bool) []string {
-	vsf := make([]string, 0)
-
+func appendUnique(vs []string, s string) []string {
 	for _, v := range vs {
-		if f(v) {
-			vsf = append(vsf, v)
+		if v == s {
+			return vs
 		}
 	}
 
-	return vsf
+	return append(vs, s)
 }
 
-func convertBillyToAfero(srcFs billy.Filesystem, srcFolder string, destFs afero.Fs, destFolder string) error {
-	files, err := srcFs.ReadDir(srcFolder)
-	if err != nil {
-		return err
-	}
-
-	for _, f := range files {
-		srcFileName := path.Join(srcFolder, f.Name())
-		destFileName := path.Join(destFolder, f.Name())
-		if f.IsDir() {
-			err = convertBillyToAfero(srcFs, srcFileName, destFs, destFileName)
-			if err != nil {
-				return err
-			}
-		}
-
-		srcFile, err := srcFs.Open(srcFileName)
-		if err != nil {
-			return err
-		}
-
-		destFile, err := destFs.Create(destFileName)
-		if err != nil {
-			return err
-		}
-
-		destFs.Chmod(destFileName, f.Mode())
-
-		_, err = io.Copy(destFile, srcFile)
-		if err != nil {
-			return err
-		}
-
-		srcFile.Close()
-		if err != nil {
-			return err
-		}
+func filterString(vs []string, f func(string) bool) []string {
+	vsf := make([]string, 0)
 
-		destFile.Close()
-		if err != nil {
-			return err
+	for _, v := range vs {
+		if f(v) {
+			vsf = append(vsf, v)
 		}
 	}
 
-	return nil
+	return vsf
 }
 
 // expand env but don't change the value if the env variable doesn't exist
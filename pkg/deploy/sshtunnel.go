@@ -11,8 +11,8 @@ import (
 	"github.com/rgzr/sshtun"
 )
 
-func (d *Deploy) setupPortForward(host string, localPort int, remoteHost string, remotePort int) *sshtun.SSHTun {
-	if !d.Bastion.Enabled || host == "" {
+func (b *Bastion) setupPortForward(host string, localPort int, remoteHost string, remotePort int) *sshtun.SSHTun {
+	if !b.Enabled || host == "" {
 		logger.Log("bastion ssh connection disabled")
 
 		return nil
@@ -24,8 +24,8 @@ func (d *Deploy) setupPortForward(host string, localPort int, remoteHost string,
 	sshTun := sshtun.New(localPort, host, remotePort)
 	sshTun.SetRemoteHost(remoteHost)
 
-	if d.Bastion.User != "" {
-		sshTun.SetUser(d.Bastion.User)
+	if b.User != "" {
+		sshTun.SetUser(b.User)
 	} else {
 		user, _ := sshConfig.Get(host, "User")
 		if user != "" {
@@ -33,8 +33,8 @@ func (d *Deploy) setupPortForward(host string, localPort int, remoteHost string,
 		}
 	}
 
-	if d.Bastion.KeyFile != "" {
-		sshTun.SetKeyFile(d.Bastion.KeyFile)
+	if b.KeyFile != "" {
+		sshTun.SetKeyFile(b.KeyFile)
 	} else {
 		keyfile, _ := sshConfig.Get(host, "IdentityFile")
 		if keyfile != "" {
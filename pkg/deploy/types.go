@@ -2,11 +2,30 @@ package deploy
 
 //go:generate go-enum -f=$GOFILE --marshal --lower
 
-import "github.com/spf13/afero"
+import (
+	"github.com/bcaldwell/kube-deploy/pkg/lib/externalsecret"
+	"github.com/bcaldwell/kube-deploy/pkg/lib/kubeapi"
+	"github.com/bcaldwell/kube-deploy/pkg/lib/sealedsecret"
+	"github.com/bcaldwell/kube-deploy/pkg/lib/secretprovider"
+	"github.com/bcaldwell/kube-deploy/pkg/lib/vaultsecret"
+	"github.com/spf13/afero"
+)
 
 type Deploy struct {
 	// github repo to clone to access the config
 	ConfigRepo string
+	// branch, tag or commit sha of the config repo to deploy, the default branch is used when empty
+	ConfigRef string
+	// number of commits to fetch when cloning a branch or tag, everything is fetched when 0
+	ConfigDepth int
+	// directory config repos are cached in between runs, repos are cloned into memory when empty
+	ConfigCacheDir string
+	// armored gpg keyring, when set the cloned config must have a commit or tag signed by one of its keys
+	ConfigKeyring string
+	// ssh allowed signers file, when set the cloned config must have a commit or tag signed by one of its keys
+	ConfigAllowedSigners string
+	// commit the config was read from, set when the config is in a git repo
+	ConfigCommit string
 	// path to the deployment config folder: relative to the github repo root or absolute if local
 	ConfigFolder string
 
@@ -14,25 +33,115 @@ type Deploy struct {
 	KubeconfigEnv string
 	// kube config path
 	KubeconfigPath string
+	// context in the kube config to deploy to, the current context is used when empty
+	KubeContext string
+	// the deploy is refused when the cluster doesn't match
+	ExpectedCluster *ClusterIdentity `json:"expectedCluster,omitempty"`
 
 	// namespace to deploy everything to
 	Namespace string
+	// create the namespaces before deploying, defaults to true. Disable when the deploy identity can't create namespaces
+	CreateNamespace *bool
+	// labels, annotations, quota and limit range for the deploy namespace
+	NamespaceConfig *NamespaceConfig
 
 	// folders inside config folder to deploy, along with metadata for how to deploy them
 	DeployFolders []DeployFolder
 
-	// environment variables to set, these variables are processed in the templates
-	Vars map[string]string
+	// secrets from providers that aren't backed by files in the deploy folders
+	Secrets []SecretDeclaration
+	// directory with ejson private keys, defaults to EJSON_KEYDIR or /opt/ejson/keys
+	EjsonKeyDir string
+	// vault connection used by vault secret declarations
+	Vault *vaultsecret.Config `json:"vault,omitempty"`
+	// what is deployed for decrypted secrets, Secrets are applied directly by default
+	SecretOutput *SecretOutput `json:"secretOutput,omitempty"`
+
+	// validate everything without changing the cluster
+	DryRun bool
+
+	// variables processed in the templates, they can be any yaml value and are set as environment variables using varToString
+	Vars Vars
 
 	Bastion *Bastion `json:"bastion,omitempty"`
 
+	// clusters to deploy to, when empty the kube config above is used. clusters without a bastion use the bastion above
+	Clusters []Cluster
+	// deploy to all clusters at the same time instead of one after another, a pointer so a target can turn it off
+	ParallelClusters *bool
+	// what to do with the remaining clusters after a cluster fails
+	FailurePolicy FailurePolicy
+
 	context
 }
 
+/*
+ENUM(
+Halt
+Continue
+)
+*/
+type FailurePolicy int
+
+/*
+ENUM(
+Secret
+SealedSecret
+ExternalSecret
+)
+*/
+type SecretOutputMode int
+
+// SecretOutput converts decrypted secrets into another kind for clusters where secrets can't be created directly
+type SecretOutput struct {
+	Mode SecretOutputMode
+	// path to the sealed secrets controller's public cert, relative to the config folder. vars are expanded so each cluster can use its own cert
+	SealedSecretsCert string
+	// strict, namespace-wide or cluster-wide, defaults to strict
+	SealedSecretsScope sealedsecret.Scope
+	// store the external secrets read from
+	ExternalSecretStore *externalsecret.Store `json:"externalSecretStore,omitempty"`
+}
+
+type Cluster struct {
+	// name used in logs and the deploy summary
+	Name string
+	// env variable to get kubeconfig from
+	KubeconfigEnv string
+	// kube config path
+	KubeconfigPath string
+	// context in the kube config, defaults to the deploy kube context
+	Context         string
+	ExpectedCluster *ClusterIdentity `json:"expectedCluster,omitempty"`
+	Bastion         *Bastion         `json:"bastion,omitempty"`
+	// vars overriding the deploy vars for this cluster, setting vars renders the config again for this cluster
+	Vars Vars
+}
+
 type context struct {
 	srcFs   afero.Fs
 	fs      afero.Fs
 	rootDir string
+	// directory on disk srcFs is rooted at, empty when the config was cloned into memory
+	localDir string
+	// cluster being deployed to
+	kube kubeapi.Client
+	// providers for secret files and declared secrets
+	secrets *secretprovider.Registry
+	// converts secrets to the configured secret output, nil when secrets are applied directly
+	convertSecret secretConverter
+	// owner label values identifying the config folder and target, secrets from removed files are pruned by them
+	app    string
+	target string
+	// namespaces the deploy touches, secrets from removed files are only pruned in them
+	deployNamespaces []string
+	// kind of the resources created from secret files, secrets or their converted kind
+	secretKind string
+	// content hashes of the secrets and config maps applied to the cluster, keyed by kind/namespace/name
+	configHashes map[string]string
+
+	// config layers in the order they were merged, used to explain where values came from
+	layers []configLayer
 }
 
 /*
@@ -51,6 +160,8 @@ type DeployFolder struct {
 	Path         string
 	Order        *int
 	HelmChart    *HelmChart `json:"helm,omitempty"`
+	// namespace to deploy the folder to, defaults to the deploy namespace
+	Namespace string
 }
 
 type HelmChart struct {
@@ -61,10 +172,48 @@ type HelmChart struct {
 	// helm chart path
 	Path string
 	// version of the helm chart to install
-	Version      string
+	Version string
+	// namespace to install the release into, overrides the folder and deploy namespace
+	Namespace    string
 	ReleaseName  string
 	PostRenderer string
 	ValuesFiles  []string
+	// when set the vars are passed to helm as values nested under this key
+	VarsValuesKey string
+	// when set a checksum of the secrets and config maps kube-deploy applied in the release namespace is passed to helm
+	// under this key, use it as a pod template annotation so workloads roll out when they change
+	ConfigChecksumValuesKey string
+}
+
+// SecretDeclaration is a secret declared in metadata.yml that is read from a secret provider instead of a file in the folder
+type SecretDeclaration struct {
+	// name of the provider to get the secret from
+	Provider string
+	// name of the secret to create
+	Name string
+	// namespace to create the secret in, defaults to the deploy namespace
+	Namespace string
+	// provider specific config
+	Config map[string]interface{}
+}
+
+// ClusterIdentity describes the cluster a deploy is expected to reach, only the fields that are set are checked
+type ClusterIdentity struct {
+	// cluster name in the kube config
+	Name string
+	// api server url
+	Server string
+	// uid of the kube-system namespace, it is unique per cluster and never changes
+	KubeSystemUID string `json:"kubeSystemUID"`
+	// config map value identifying the cluster
+	ConfigMap *ConfigMapIdentity `json:"configMap,omitempty"`
+}
+
+type ConfigMapIdentity struct {
+	Namespace string
+	Name      string
+	Key       string
+	Value     string
 }
 
 type Bastion struct {
@@ -70,3 +70,114 @@ func (x *RenderEngine) UnmarshalText(text []byte) error {
 	*x = tmp
 	return nil
 }
+
+const (
+	// FailurePolicyHalt is a FailurePolicy of type Halt
+	FailurePolicyHalt FailurePolicy = iota
+	// FailurePolicyContinue is a FailurePolicy of type Continue
+	FailurePolicyContinue
+)
+
+const _FailurePolicyName = "HaltContinue"
+
+var _FailurePolicyMap = map[FailurePolicy]string{
+	0: _FailurePolicyName[0:4],
+	1: _FailurePolicyName[4:12],
+}
+
+// String implements the Stringer interface.
+func (x FailurePolicy) String() string {
+	if str, ok := _FailurePolicyMap[x]; ok {
+		return str
+	}
+	return fmt.Sprintf("FailurePolicy(%d)", x)
+}
+
+var _FailurePolicyValue = map[string]FailurePolicy{
+	_FailurePolicyName[0:4]:                   0,
+	strings.ToLower(_FailurePolicyName[0:4]):  0,
+	_FailurePolicyName[4:12]:                  1,
+	strings.ToLower(_FailurePolicyName[4:12]): 1,
+}
+
+// ParseFailurePolicy attempts to convert a string to a FailurePolicy
+func ParseFailurePolicy(name string) (FailurePolicy, error) {
+	if x, ok := _FailurePolicyValue[name]; ok {
+		return x, nil
+	}
+	return FailurePolicy(0), fmt.Errorf("%s is not a valid FailurePolicy", name)
+}
+
+// MarshalText implements the text marshaller method
+func (x FailurePolicy) MarshalText() ([]byte, error) {
+	return []byte(x.String()), nil
+}
+
+// UnmarshalText implements the text unmarshaller method
+func (x *FailurePolicy) UnmarshalText(text []byte) error {
+	name := string(text)
+	tmp, err := ParseFailurePolicy(name)
+	if err != nil {
+		return err
+	}
+	*x = tmp
+	return nil
+}
+
+const (
+	// SecretOutputModeSecret is a SecretOutputMode of type Secret
+	SecretOutputModeSecret SecretOutputMode = iota
+	// SecretOutputModeSealedSecret is a SecretOutputMode of type SealedSecret
+	SecretOutputModeSealedSecret
+	// SecretOutputModeExternalSecret is a SecretOutputMode of type ExternalSecret
+	SecretOutputModeExternalSecret
+)
+
+const _SecretOutputModeName = "SecretSealedSecretExternalSecret"
+
+var _SecretOutputModeMap = map[SecretOutputMode]string{
+	0: _SecretOutputModeName[0:6],
+	1: _SecretOutputModeName[6:18],
+	2: _SecretOutputModeName[18:32],
+}
+
+// String implements the Stringer interface.
+func (x SecretOutputMode) String() string {
+	if str, ok := _SecretOutputModeMap[x]; ok {
+		return str
+	}
+	return fmt.Sprintf("SecretOutputMode(%d)", x)
+}
+
+var _SecretOutputModeValue = map[string]SecretOutputMode{
+	_SecretOutputModeName[0:6]:                    0,
+	strings.ToLower(_SecretOutputModeName[0:6]):   0,
+	_SecretOutputModeName[6:18]:                   1,
+	strings.ToLower(_SecretOutputModeName[6:18]):  1,
+	_SecretOutputModeName[18:32]:                  2,
+	strings.ToLower(_SecretOutputModeName[18:32]): 2,
+}
+
+// ParseSecretOutputMode attempts to convert a string to a SecretOutputMode
+func ParseSecretOutputMode(name string) (SecretOutputMode, error) {
+	if x, ok := _SecretOutputModeValue[name]; ok {
+		return x, nil
+	}
+	return SecretOutputMode(0), fmt.Errorf("%s is not a valid SecretOutputMode", name)
+}
+
+// MarshalText implements the text marshaller method
+func (x SecretOutputMode) MarshalText() ([]byte, error) {
+	return []byte(x.String()), nil
+}
+
+// UnmarshalText implements the text unmarshaller method
+func (x *SecretOutputMode) UnmarshalText(text []byte) error {
+	name := string(text)
+	tmp, err := ParseSecretOutputMode(name)
+	if err != nil {
+		return err
+	}
+	*x = tmp
+	return nil
+}
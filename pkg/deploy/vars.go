@@ -0,0 +1,426 @@
+package deploy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/ghodss/yaml"
+	"github.com/spf13/afero"
+)
+
+// templateSuffix marks files that are rendered as go templates instead of having env variables expanded
+const templateSuffix = ".gotmpl"
+
+// Vars can hold any yaml value, nested maps and lists keep their structure for templates and helm values
+type Vars map[string]interface{}
+
+// mergeVars deep merges src over dst and returns the result without modifying either map.
+// nested maps are merged key by key, any other value in src replaces the value in dst
+func mergeVars(dst, src Vars) Vars {
+	merged := make(Vars, len(dst)+len(src))
+
+	for k, v := range dst {
+		merged[k] = v
+	}
+
+	for k, v := range src {
+		srcMap, srcIsMap := toVarsMap(v)
+		dstMap, dstIsMap := toVarsMap(merged[k])
+
+		if srcIsMap && dstIsMap {
+			merged[k] = map[string]interface{}(mergeVars(dstMap, srcMap))
+			continue
+		}
+
+		merged[k] = v
+	}
+
+	return merged
+}
+
+func toVarsMap(v interface{}) (Vars, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return Vars(m), true
+	case Vars:
+		return m, true
+	}
+
+	return nil, false
+}
+
+// varToString is the serialization used when a var is expanded into a string or set as an env variable.
+// strings are used as is, numbers and bools use their yaml representation and lists and maps are encoded as json
+func varToString(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case bool:
+		return strconv.FormatBool(val)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(val), 'f', -1, 32)
+	case int, int32, int64, uint, uint32, uint64:
+		return fmt.Sprintf("%d", val)
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+
+	return string(b)
+}
+
+// processFile expands env variables in src, files ending in .gotmpl are rendered as go templates with the vars instead
+func (d *Deploy) processFile(path string, src string) (string, error) {
+	if !strings.HasSuffix(path, templateSuffix) {
+		return os.Expand(src, d.expandVar), nil
+	}
+
+	tmpl, err := template.New(path).Option("missingkey=error").Funcs(template.FuncMap{
+		"toJson": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		"toYaml": func(v interface{}) (string, error) {
+			b, err := yaml.Marshal(v)
+			return strings.TrimSuffix(string(b), "\n"), err
+		},
+	}).Parse(src)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template %s: %w", path, err)
+	}
+
+	var out bytes.Buffer
+
+	err = tmpl.Execute(&out, map[string]interface{}{
+		"Vars":      map[string]interface{}(d.Vars),
+		"Namespace": d.Namespace,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to render template %s: %w", path, err)
+	}
+
+	return out.String(), nil
+}
+
+// expandVar looks up vars before env variables so vars that differ from the environment, like cluster vars, are used
+func (d *Deploy) expandVar(s string) string {
+	if v, ok := d.Vars[s]; ok {
+		return varToString(v)
+	}
+
+	return expandEnvSafe(s)
+}
+
+// renameRenderedTemplates drops the .gotmpl suffix from rendered templates so kubectl and helm pick them up
+func renameRenderedTemplates(fs afero.Fs, folder string) error {
+	files, err := listAllFilesInFolder(fs, folder)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if !strings.HasSuffix(f, templateSuffix) {
+			continue
+		}
+
+		err = fs.Rename(f, strings.TrimSuffix(f, templateSuffix))
+		if err != nil {
+			return fmt.Errorf("failed to rename rendered template %s: %w", f, err)
+		}
+	}
+
+	return nil
+}
+
+// ValueFrom sources a var from somewhere other than metadata.yml, exactly one source should be set
+type ValueFrom struct {
+	// file relative to the config folder, trailing new lines are trimmed
+	File string
+	// shell command to run, stdout with trailing new lines trimmed is used as the value
+	Command string
+	// environment variable to read
+	Env string
+	// other var to copy the value from
+	Var string
+	// used when the source does not exist or is empty, errors from the source are still returned
+	Default interface{}
+}
+
+// errValueMissing is returned when a valueFrom source doesn't exist, it is replaced by the default when one is set
+var errValueMissing = errors.New("value is missing")
+
+type varResolver struct {
+	fs       afero.Fs
+	localDir string
+	folder   string
+	vars     Vars
+
+	resolved  Vars
+	resolving map[string]bool
+	stack     []string
+}
+
+// resolveVars resolves valueFrom vars and ${VAR} references to other vars in string values
+func resolveVars(fs afero.Fs, localDir string, folder string, vars Vars) (Vars, error) {
+	return resolveVarsOver(fs, localDir, folder, nil, vars)
+}
+
+// resolveVarsOver merges vars over vars that were already resolved and resolves the result.
+// only the keys in vars are resolved, they can reference the resolved vars
+func resolveVarsOver(fs afero.Fs, localDir string, folder string, resolved Vars, vars Vars) (Vars, error) {
+	r := &varResolver{
+		fs:        fs,
+		localDir:  localDir,
+		folder:    folder,
+		vars:      mergeVars(resolved, vars),
+		resolved:  make(Vars, len(resolved)+len(vars)),
+		resolving: make(map[string]bool),
+	}
+
+	for k, v := range resolved {
+		if _, ok := vars[k]; !ok {
+			r.resolved[k] = v
+		}
+	}
+
+	for k := range r.vars {
+		if _, err := r.resolve(k); err != nil {
+			return nil, err
+		}
+	}
+
+	return r.resolved, nil
+}
+
+func (r *varResolver) resolve(name string) (interface{}, error) {
+	if v, ok := r.resolved[name]; ok {
+		return v, nil
+	}
+
+	if r.resolving[name] {
+		return nil, fmt.Errorf("var reference cycle detected: %s -> %s", strings.Join(r.stack, " -> "), name)
+	}
+
+	r.resolving[name] = true
+	r.stack = append(r.stack, name)
+
+	defer func() {
+		r.stack = r.stack[:len(r.stack)-1]
+		delete(r.resolving, name)
+	}()
+
+	v, err := r.resolveValue(r.vars[name])
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve var %s: %w", name, err)
+	}
+
+	r.resolved[name] = v
+
+	return v, nil
+}
+
+func (r *varResolver) resolveValue(v interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case string:
+		return r.interpolate(val)
+	case []interface{}:
+		list := make([]interface{}, len(val))
+
+		for i, item := range val {
+			resolved, err := r.resolveValue(item)
+			if err != nil {
+				return nil, err
+			}
+
+			list[i] = resolved
+		}
+
+		return list, nil
+	case map[string]interface{}:
+		if valueFrom, ok := val["valueFrom"]; ok && len(val) == 1 {
+			return r.resolveValueFrom(valueFrom)
+		}
+
+		m := make(map[string]interface{}, len(val))
+
+		for k, item := range val {
+			resolved, err := r.resolveValue(item)
+			if err != nil {
+				return nil, err
+			}
+
+			m[k] = resolved
+		}
+
+		return m, nil
+	}
+
+	return v, nil
+}
+
+// interpolate replaces ${VAR} with the value of other vars. only references to declared vars are replaced,
+// everything else, like $VAR, $$ or ${UNKNOWN}, is copied as is so passwords and shell snippets aren't changed
+func (r *varResolver) interpolate(s string) (interface{}, error) {
+	var out strings.Builder
+
+	for {
+		start := strings.Index(s, "${")
+		if start == -1 {
+			break
+		}
+
+		end := strings.Index(s[start:], "}")
+		if end == -1 {
+			break
+		}
+
+		end += start
+		name := s[start+2 : end]
+
+		if _, ok := r.vars[name]; !ok {
+			out.WriteString(s[:start+2])
+			s = s[start+2:]
+
+			continue
+		}
+
+		v, err := r.resolve(name)
+		if err != nil {
+			return nil, err
+		}
+
+		out.WriteString(s[:start])
+		out.WriteString(varToString(v))
+		s = s[end+1:]
+	}
+
+	out.WriteString(s)
+
+	return out.String(), nil
+}
+
+func (r *varResolver) resolveValueFrom(raw interface{}) (interface{}, error) {
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	valueFrom := ValueFrom{}
+
+	err = json.Unmarshal(b, &valueFrom)
+	if err != nil {
+		return nil, fmt.Errorf("invalid valueFrom: %w", err)
+	}
+
+	var value interface{}
+
+	switch {
+	case valueFrom.Var != "":
+		if _, ok := r.vars[valueFrom.Var]; !ok {
+			err = fmt.Errorf("%w: var %s does not exist", errValueMissing, valueFrom.Var)
+			break
+		}
+
+		value, err = r.resolve(valueFrom.Var)
+	case valueFrom.Env != "":
+		if env, ok := os.LookupEnv(valueFrom.Env); ok {
+			value = env
+		} else {
+			err = fmt.Errorf("%w: environment variable %s is not set", errValueMissing, valueFrom.Env)
+		}
+	case valueFrom.File != "":
+		var content []byte
+
+		content, err = afero.ReadFile(r.fs, path.Join(r.folder, valueFrom.File))
+		if os.IsNotExist(err) {
+			err = fmt.Errorf("%w: file %s does not exist", errValueMissing, valueFrom.File)
+		}
+
+		value = strings.TrimRight(string(content), "\r\n")
+	case valueFrom.Command != "":
+		value, err = r.runCommand(valueFrom.Command)
+	default:
+		return nil, fmt.Errorf("valueFrom must set one of file, command, env or var")
+	}
+
+	missing := errors.Is(err, errValueMissing) || (err == nil && value == "")
+	if missing && valueFrom.Default != nil {
+		return valueFrom.Default, nil
+	}
+
+	return value, err
+}
+
+// valueFromFiles returns the files read by valueFrom vars in v, relative to the root of the fs
+func valueFromFiles(folder string, v interface{}) []string {
+	files := []string{}
+
+	switch val := v.(type) {
+	case []interface{}:
+		for _, item := range val {
+			files = append(files, valueFromFiles(folder, item)...)
+		}
+	case map[string]interface{}, Vars:
+		m, _ := toVarsMap(val)
+
+		if raw, ok := m["valueFrom"]; ok && len(m) == 1 {
+			valueFrom := ValueFrom{}
+
+			// invalid valueFroms are reported when the vars are resolved
+			if b, err := json.Marshal(raw); err == nil && json.Unmarshal(b, &valueFrom) == nil && valueFrom.File != "" {
+				files = append(files, path.Join(folder, valueFrom.File))
+			}
+
+			return files
+		}
+
+		for _, item := range m {
+			files = append(files, valueFromFiles(folder, item)...)
+		}
+	}
+
+	return files
+}
+
+func (r *varResolver) runCommand(command string) (string, error) {
+	cmd := exec.Command("sh", "-c", command)
+
+	// commands run from the config folder when it is on disk, cloned config only exists in memory
+	if r.localDir != "" {
+		cmd.Dir = path.Join(r.localDir, r.folder)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("command %q failed: %w: %s", command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+func varsToEnv(vars Vars) []string {
+	env := make([]string, 0, len(vars))
+
+	for k, v := range vars {
+		env = append(env, k+"="+varToString(v))
+	}
+
+	return env
+}
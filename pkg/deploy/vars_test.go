@@ -0,0 +1,197 @@
+package deploy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestResolveVarsInterpolation(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{name: "literal dollar", value: "pa$word", expected: "pa$word"},
+		{name: "double dollar", value: "pa$$word", expected: "pa$$word"},
+		{name: "bcrypt hash", value: "$2a$10$abc", expected: "$2a$10$abc"},
+		{name: "unbraced var", value: "$HOST", expected: "$HOST"},
+		{name: "unknown var", value: "${UNKNOWN}", expected: "${UNKNOWN}"},
+		{name: "unclosed reference", value: "${HOST", expected: "${HOST"},
+		{name: "declared var", value: "http://${HOST}:${PORT}", expected: "http://db:5432"},
+		{name: "chained reference", value: "${URL}/app", expected: "http://db:5432/app"},
+		{name: "unknown wrapping declared", value: "${${HOST}}", expected: "${db}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars, err := resolveVars(afero.NewMemMapFs(), "", ".", Vars{
+				"HOST":  "db",
+				"PORT":  5432,
+				"URL":   "http://${HOST}:${PORT}",
+				"VALUE": tt.value,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if vars["VALUE"] != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, vars["VALUE"])
+			}
+		})
+	}
+}
+
+func TestResolveVarsCycle(t *testing.T) {
+	_, err := resolveVars(afero.NewMemMapFs(), "", ".", Vars{
+		"A": "${B}",
+		"B": "${C}",
+		"C": "${A}",
+	})
+	if err == nil || !strings.Contains(err.Error(), "var reference cycle detected") {
+		t.Fatalf("expected a cycle error, got %v", err)
+	}
+}
+
+func TestResolveVarsValueFrom(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	if err := afero.WriteFile(fs, "app/token.txt", []byte("s3cret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	vars, err := resolveVars(fs, "", "app", Vars{
+		"TOKEN":   map[string]interface{}{"valueFrom": map[string]interface{}{"file": "token.txt"}},
+		"MISSING": map[string]interface{}{"valueFrom": map[string]interface{}{"file": "missing.txt", "default": "fallback"}},
+		"COPY":    map[string]interface{}{"valueFrom": map[string]interface{}{"var": "TOKEN"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]string{"TOKEN": "s3cret", "MISSING": "fallback", "COPY": "s3cret"} {
+		if vars[name] != expected {
+			t.Errorf("expected %s to be %q, got %q", name, expected, vars[name])
+		}
+	}
+}
+
+func TestResolveVarsOver(t *testing.T) {
+	resolved := Vars{
+		"HOST": "db",
+		// already resolved values aren't interpolated again
+		"LITERAL": "${HOST}",
+	}
+
+	vars, err := resolveVarsOver(afero.NewMemMapFs(), "", ".", resolved, Vars{
+		"REGION": "us-east",
+		"URL":    "${HOST}.${REGION}",
+		"TOKEN":  map[string]interface{}{"valueFrom": map[string]interface{}{"env": "KUBE_DEPLOY_UNSET_TEST_VAR", "default": "none"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]string{"URL": "db.us-east", "LITERAL": "${HOST}", "TOKEN": "none"} {
+		if vars[name] != expected {
+			t.Errorf("expected %s to be %q, got %q", name, expected, vars[name])
+		}
+	}
+}
+
+func TestMergeVars(t *testing.T) {
+	tests := []struct {
+		name     string
+		dst      Vars
+		src      Vars
+		expected Vars
+	}{
+		{
+			name:     "src wins",
+			dst:      Vars{"A": "dst", "B": "dst"},
+			src:      Vars{"B": "src", "C": "src"},
+			expected: Vars{"A": "dst", "B": "src", "C": "src"},
+		},
+		{
+			name: "nested maps are merged",
+			dst:  Vars{"DB": map[string]interface{}{"host": "db", "port": 5432, "opts": map[string]interface{}{"ssl": true}}},
+			src:  Vars{"DB": map[string]interface{}{"port": 6432, "opts": map[string]interface{}{"timeout": 5}}},
+			expected: Vars{"DB": map[string]interface{}{
+				"host": "db",
+				"port": 6432,
+				"opts": map[string]interface{}{"ssl": true, "timeout": 5},
+			}},
+		},
+		{
+			name:     "lists are replaced",
+			dst:      Vars{"HOSTS": []interface{}{"a", "b"}},
+			src:      Vars{"HOSTS": []interface{}{"c"}},
+			expected: Vars{"HOSTS": []interface{}{"c"}},
+		},
+		{
+			name:     "a scalar replaces a map",
+			dst:      Vars{"DB": map[string]interface{}{"host": "db"}},
+			src:      Vars{"DB": "postgres://db"},
+			expected: Vars{"DB": "postgres://db"},
+		},
+		{
+			name:     "nil maps",
+			dst:      nil,
+			src:      Vars{"A": false},
+			expected: Vars{"A": false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := mergeVars(tt.dst, tt.src)
+
+			if !reflect.DeepEqual(merged, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, merged)
+			}
+		})
+	}
+}
+
+func TestMergeVarsDoesNotModifyInputs(t *testing.T) {
+	dst := Vars{"DB": map[string]interface{}{"host": "db"}}
+	src := Vars{"DB": map[string]interface{}{"port": 5432}}
+
+	mergeVars(dst, src)
+
+	if !reflect.DeepEqual(dst, Vars{"DB": map[string]interface{}{"host": "db"}}) {
+		t.Errorf("expected dst to be unchanged, got %v", dst)
+	}
+
+	if !reflect.DeepEqual(src, Vars{"DB": map[string]interface{}{"port": 5432}}) {
+		t.Errorf("expected src to be unchanged, got %v", src)
+	}
+}
+
+func TestVarToString(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{value: nil, expected: ""},
+		{value: "text", expected: "text"},
+		{value: true, expected: "true"},
+		{value: false, expected: "false"},
+		{value: 3, expected: "3"},
+		{value: int64(-7), expected: "-7"},
+		{value: float64(3), expected: "3"},
+		{value: 1.5, expected: "1.5"},
+		{value: float64(1e21), expected: "1000000000000000000000"},
+		{value: float32(0.25), expected: "0.25"},
+		{value: []interface{}{"a", 1, true}, expected: `["a",1,true]`},
+		{value: map[string]interface{}{"b": 2, "a": "x"}, expected: `{"a":"x","b":2}`},
+	}
+
+	for _, tt := range tests {
+		if s := varToString(tt.value); s != tt.expected {
+			t.Errorf("expected %#v to be %q, got %q", tt.value, tt.expected, s)
+		}
+	}
+}
@@ -1,92 +1,151 @@
 package ejsonsecret
 
 import (
+	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 
 	"github.com/Shopify/ejson"
-	"github.com/bcaldwell/kube-deploy/pkg/lib/kubeapi"
-	"github.com/bcaldwell/kube-deploy/pkg/lib/logger"
+	"github.com/bcaldwell/kube-deploy/pkg/lib/secretprovider"
 	v1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-type ejsonSecret struct {
-	Name      string                 `json:"_name"`
-	Namespace string                 `json:"_namespace"`
-	Data      map[string]interface{} `json:"data"`
+// DefaultKeyDir is where ejson looks for private keys when no key dir is set
+const DefaultKeyDir = "/opt/ejson/keys"
+
+var InvalidEjsonSecret = secretprovider.ErrInvalidSecret
+
+// DecryptError is returned when an ejson file can't be decrypted, it lists where the private key was looked for
+type DecryptError struct {
+	File       string
+	PublicKey  string
+	KeySources []string
+	Err        error
 }
 
-var InvalidEjsonSecret = errors.New("ejson secret is invalid")
+func (e *DecryptError) Error() string {
+	return fmt.Sprintf("failed to decrypt %s with public key %s, tried key sources %s: %s", e.File, e.PublicKey, strings.Join(e.KeySources, ", "), e.Err)
+}
 
-func DeploySecret(secretsFile string, namespace string, ejsonKey string) error {
-	logger.Log("create kubernetes secret from %s", secretsFile)
+func (e *DecryptError) Unwrap() error {
+	return e.Err
+}
 
-	var inputSecret ejsonSecret
+// Provider decrypts .ejson files with _name, _namespace and data keys into secrets
+type Provider struct {
+	// private key used when the key isn't found in the key dir
+	Key string
+	// directory containing private keys named after their public key
+	KeyDir string
+	// descriptions of where the private key is looked for, used in errors
+	KeySources []string
+}
 
-	encryptedFile, err := ioutil.ReadFile(secretsFile)
-	if err != nil {
-		return fmt.Errorf("failed to read %s: %w", secretsFile, err)
+// NewProvider returns a provider using the private key from EJSON_KEY or the file in EJSON_KEY_PATH and keys in keyDir.
+// keyDir defaults to EJSON_KEYDIR and then DefaultKeyDir
+func NewProvider(keyDir string) (Provider, error) {
+	p := Provider{
+		Key:    os.Getenv("EJSON_KEY"),
+		KeyDir: keyDir,
 	}
 
-	if err := json.Unmarshal(encryptedFile, &inputSecret); err != nil {
-		return fmt.Errorf("Failed to unmarshal decrypted json file %w", err)
+	if p.Key != "" {
+		p.KeySources = append(p.KeySources, "EJSON_KEY")
 	}
 
-	if inputSecret.Name == "" {
-		logger.Log("skipping creating ejson secret: _name can not be blank")
-		return fmt.Errorf("%w: _name can not be blank", InvalidEjsonSecret)
+	if keyPath := os.Getenv("EJSON_KEY_PATH"); keyPath != "" {
+		p.KeySources = append(p.KeySources, fmt.Sprintf("EJSON_KEY_PATH (%s)", keyPath))
+
+		if p.Key == "" {
+			b, err := ioutil.ReadFile(keyPath)
+			if err != nil {
+				return p, fmt.Errorf("failed to read ejson key from EJSON_KEY_PATH: %w", err)
+			}
+
+			p.Key = strings.TrimSpace(string(b))
+		}
 	}
 
-	// set namespace to default value if no namespace is set in the secret
-	if inputSecret.Namespace == "" {
-		inputSecret.Namespace = namespace
+	if p.KeyDir == "" {
+		p.KeyDir = os.Getenv("EJSON_KEYDIR")
 	}
 
-	if inputSecret.Namespace == "" {
-		logger.Log("skipping creating ejson secret: _namespace can not be blank")
-		return fmt.Errorf("%w: _namespace can not be blank", InvalidEjsonSecret)
+	if p.KeyDir == "" {
+		p.KeyDir = DefaultKeyDir
 	}
 
-	decryptedSource, err := ejson.DecryptFile(secretsFile, "/opt/ejson/keys", ejsonKey)
+	p.KeySources = append(p.KeySources, fmt.Sprintf("key dir %s", p.KeyDir))
+
+	return p, nil
+}
+
+func (Provider) Name() string {
+	return "ejson"
+}
+
+func (p Provider) Secrets(source secretprovider.Source) ([]v1.Secret, error) {
+	encryptedFile := source.Content
+	if encryptedFile == nil {
+		var err error
+
+		encryptedFile, err = ioutil.ReadFile(source.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", source.Path, err)
+		}
+	}
+
+	// validate the secret before decrypting so files that aren't secrets don't need a key
+	err := secretprovider.CheckDocument(encryptedFile)
 	if err != nil {
-		fmt.Printf("Error: failed to decrypt ejson file %s\n", err)
-		os.Exit(1)
+		return nil, err
 	}
 
-	if err := json.Unmarshal(decryptedSource, &inputSecret); err != nil {
-		return fmt.Errorf("Failed to unmarshal decrypted json file %w", err)
+	decryptedSource, err := p.Decrypt(source.Path, encryptedFile)
+	if err != nil {
+		return nil, err
 	}
 
-	secret := v1.Secret{
-		TypeMeta: metav1.TypeMeta{
-			Kind:       "Secret",
-			APIVersion: "v1",
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      inputSecret.Name,
-			Namespace: inputSecret.Namespace,
-		},
-		Data: make(map[string][]byte),
-		Type: v1.SecretTypeOpaque,
+	secret, err := secretprovider.SecretFromDocument(decryptedSource, source.Namespace)
+	if err != nil {
+		return nil, err
 	}
 
-	// convert secrets to base64
-	for key, value := range inputSecret.Data {
-		var bytes []byte
-		if s, ok := value.(string); ok {
-			bytes = []byte(s)
-		} else {
-			bytes, _ = json.Marshal(value)
-		}
+	return []v1.Secret{secret}, nil
+}
 
-		secret.Data[key] = bytes
+// Decrypt decrypts the content of an ejson file, path is only used in errors
+func (p Provider) Decrypt(path string, content []byte) ([]byte, error) {
+	keyDir := p.KeyDir
+	if keyDir == "" {
+		keyDir = DefaultKeyDir
 	}
 
-	logger.Log("creating secret %s in %s", inputSecret.Name, inputSecret.Namespace)
+	var decrypted bytes.Buffer
+
+	err := ejson.Decrypt(bytes.NewReader(content), &decrypted, keyDir, p.Key)
+	if err != nil {
+		return nil, &DecryptError{
+			File:       path,
+			PublicKey:  publicKey(content),
+			KeySources: p.KeySources,
+			Err:        err,
+		}
+	}
+
+	return decrypted.Bytes(), nil
+}
+
+func publicKey(content []byte) string {
+	doc := struct {
+		PublicKey string `json:"_public_key"`
+	}{}
+
+	if err := json.Unmarshal(content, &doc); err != nil || doc.PublicKey == "" {
+		return "<missing _public_key>"
+	}
 
-	return kubeapi.ApplyResource(secret)
+	return doc.PublicKey
 }
@@ -0,0 +1,193 @@
+package ejsonsecret
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Shopify/ejson"
+)
+
+// NewDocument returns an encrypted ejson secret document. Data values are encrypted with publicKey
+func NewDocument(publicKey string, name string, namespace string, data map[string]string) ([]byte, error) {
+	doc := map[string]interface{}{
+		"_public_key": publicKey,
+		"_name":       name,
+		"data":        data,
+	}
+
+	if namespace != "" {
+		doc["_namespace"] = namespace
+	}
+
+	plaintext, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return Encrypt(plaintext)
+}
+
+// Encrypt encrypts every value in an ejson document that isn't already encrypted using the document's _public_key
+func Encrypt(plaintext []byte) ([]byte, error) {
+	var encrypted bytes.Buffer
+
+	_, err := ejson.Encrypt(bytes.NewReader(plaintext), &encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt ejson document: %w", err)
+	}
+
+	return encrypted.Bytes(), nil
+}
+
+// GenerateKey generates a new keypair. The private key is written to keyDir when writeKey is set, otherwise it is returned
+func GenerateKey(keyDir string, writeKey bool) (publicKey string, privateKey string, err error) {
+	publicKey, privateKey, err = ejson.GenerateKeypair()
+	if err != nil {
+		return "", "", err
+	}
+
+	if !writeKey {
+		return publicKey, privateKey, nil
+	}
+
+	err = os.MkdirAll(keyDir, 0700)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(keyDir, publicKey), []byte(privateKey), 0400)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to write private key to %s: %w", keyDir, err)
+	}
+
+	return publicKey, "", nil
+}
+
+// Rotate decrypts an ejson file and encrypts it again with newPublicKey
+func (p Provider) Rotate(path string, newPublicKey string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	decrypted, err := p.Decrypt(path, content)
+	if err != nil {
+		return err
+	}
+
+	doc := map[string]interface{}{}
+
+	err = json.Unmarshal(decrypted, &doc)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal decrypted %s: %w", path, err)
+	}
+
+	doc["_public_key"] = newPublicKey
+
+	plaintext, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := Encrypt(plaintext)
+	if err != nil {
+		return err
+	}
+
+	return WriteFile(path, encrypted)
+}
+
+// Edit decrypts an ejson file, passes the plaintext to edit and encrypts the result back into the file
+func (p Provider) Edit(path string, edit func(plaintext []byte) ([]byte, error)) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	decrypted, err := p.Decrypt(path, content)
+	if err != nil {
+		return err
+	}
+
+	edited, err := edit(decrypted)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(edited, decrypted) {
+		return nil
+	}
+
+	if !json.Valid(edited) {
+		return fmt.Errorf("edited %s is not valid json", path)
+	}
+
+	encrypted, err := Encrypt(edited)
+	if err != nil {
+		return err
+	}
+
+	return WriteFile(path, encrypted)
+}
+
+// View returns the ejson file with data values redacted, or decrypted when reveal is set
+func (p Provider) View(path string, reveal bool) ([]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if reveal {
+		return p.Decrypt(path, content)
+	}
+
+	doc := map[string]interface{}{}
+
+	err = json.Unmarshal(content, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %w", path, err)
+	}
+
+	redact(doc)
+
+	var out bytes.Buffer
+
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+
+	err = enc.Encode(doc)
+
+	return out.Bytes(), err
+}
+
+// redact replaces every value that ejson would encrypt, keys starting with _ are left as is
+func redact(doc map[string]interface{}) {
+	for k, v := range doc {
+		if strings.HasPrefix(k, "_") {
+			continue
+		}
+
+		switch v := v.(type) {
+		case map[string]interface{}:
+			redact(v)
+		default:
+			doc[k] = "<redacted>"
+		}
+	}
+}
+
+// WriteFile writes an ejson file keeping the mode of the existing file
+func WriteFile(path string, content []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode()
+	}
+
+	return ioutil.WriteFile(path, content, mode)
+}
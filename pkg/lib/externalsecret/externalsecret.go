@@ -0,0 +1,121 @@
+package externalsecret
+
+import (
+	"os"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Store is the SecretStore or ClusterSecretStore the generated ExternalSecrets read from
+type Store struct {
+	// name of the store
+	Name string
+	// SecretStore or ClusterSecretStore, defaults to SecretStore
+	Kind string
+	// how often the external secrets operator syncs the secret, defaults to 1h
+	RefreshInterval string
+	// key of the secret in the store, ${NAMESPACE} and ${NAME} are replaced with the secret's namespace and name.
+	// defaults to ${NAMESPACE}/${NAME}
+	RemoteKey string
+}
+
+// Manifest returns an ExternalSecret that creates secret from the store. Only the keys of secret are used,
+// each key is read from the property with the same name in the remote key
+func (s Store) Manifest(secret v1.Secret) map[string]interface{} {
+	kind := s.Kind
+	if kind == "" {
+		kind = "SecretStore"
+	}
+
+	refreshInterval := s.RefreshInterval
+	if refreshInterval == "" {
+		refreshInterval = "1h"
+	}
+
+	remoteKeyTemplate := s.RemoteKey
+	if remoteKeyTemplate == "" {
+		remoteKeyTemplate = "${NAMESPACE}/${NAME}"
+	}
+
+	remoteKey := os.Expand(remoteKeyTemplate, func(v string) string {
+		switch v {
+		case "NAMESPACE":
+			return secret.Namespace
+		case "NAME":
+			return secret.Name
+		}
+
+		return ""
+	})
+
+	keys := make([]string, 0, len(secret.Data)+len(secret.StringData))
+	for k := range secret.Data {
+		keys = append(keys, k)
+	}
+
+	for k := range secret.StringData {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	data := make([]interface{}, 0, len(keys))
+	for _, k := range keys {
+		data = append(data, map[string]interface{}{
+			"secretKey": k,
+			"remoteRef": map[string]interface{}{
+				"key":      remoteKey,
+				"property": k,
+			},
+		})
+	}
+
+	templateMetadata := map[string]interface{}{}
+	if len(secret.Labels) != 0 {
+		templateMetadata["labels"] = secret.Labels
+	}
+
+	if len(secret.Annotations) != 0 {
+		templateMetadata["annotations"] = secret.Annotations
+	}
+
+	template := map[string]interface{}{
+		"metadata": templateMetadata,
+	}
+
+	if secret.Type != "" {
+		template["type"] = string(secret.Type)
+	}
+
+	metadata := map[string]interface{}{
+		"name":      secret.Name,
+		"namespace": secret.Namespace,
+	}
+
+	if len(secret.Labels) != 0 {
+		metadata["labels"] = secret.Labels
+	}
+
+	if len(secret.Annotations) != 0 {
+		metadata["annotations"] = secret.Annotations
+	}
+
+	return map[string]interface{}{
+		"apiVersion": "external-secrets.io/v1beta1",
+		"kind":       "ExternalSecret",
+		"metadata":   metadata,
+		"spec": map[string]interface{}{
+			"refreshInterval": refreshInterval,
+			"secretStoreRef": map[string]interface{}{
+				"name": s.Name,
+				"kind": kind,
+			},
+			"target": map[string]interface{}{
+				"name":     secret.Name,
+				"template": template,
+			},
+			"data": data,
+		},
+	}
+}
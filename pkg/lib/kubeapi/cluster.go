@@ -0,0 +1,62 @@
+package kubeapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// ClusterInfo identifies the cluster a client talks to
+type ClusterInfo struct {
+	Context string
+	Cluster string
+	Server  string
+}
+
+func (i ClusterInfo) String() string {
+	return fmt.Sprintf("%s (context %s, server %s)", i.Cluster, i.Context, i.Server)
+}
+
+type kubeConfigView struct {
+	CurrentContext string `json:"current-context"`
+	Clusters       []struct {
+		Name    string `json:"name"`
+		Cluster struct {
+			Server string `json:"server"`
+		} `json:"cluster"`
+	} `json:"clusters"`
+}
+
+// ClusterInfo reads the cluster name and api server for the client's context from the kube config
+func (c Client) ClusterInfo() (ClusterInfo, error) {
+	out, err := c.Kubectl("config", "view", "--minify", "-o", "json").Output()
+	if err != nil {
+		return ClusterInfo{}, fmt.Errorf("failed to read kube config: %w", err)
+	}
+
+	view := kubeConfigView{}
+
+	err = json.Unmarshal(out, &view)
+	if err != nil {
+		return ClusterInfo{}, fmt.Errorf("failed to parse kube config: %w", err)
+	}
+
+	// in cluster service account access doesn't have a kube config
+	if len(view.Clusters) == 0 {
+		if host := os.Getenv("KUBERNETES_SERVICE_HOST"); host != "" {
+			return ClusterInfo{
+				Context: "in-cluster",
+				Cluster: "in-cluster",
+				Server:  fmt.Sprintf("https://%s:%s", host, os.Getenv("KUBERNETES_SERVICE_PORT")),
+			}, nil
+		}
+
+		return ClusterInfo{}, fmt.Errorf("kube config does not define a cluster for context %q", c.Context)
+	}
+
+	return ClusterInfo{
+		Context: view.CurrentContext,
+		Cluster: view.Clusters[0].Name,
+		Server:  view.Clusters[0].Cluster.Server,
+	}, nil
+}
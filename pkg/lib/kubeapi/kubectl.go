@@ -8,13 +8,60 @@ import (
 	"os/exec"
 )
 
-func ApplyResource(resource interface{}) error {
+// Client runs kubectl and helm against a single cluster. The zero value uses the cluster from the environment
+type Client struct {
+	// path to the kube config, KUBECONFIG from the environment is used when empty
+	Kubeconfig string
+	// context in the kube config to use, the current context is used when empty
+	Context string
+	// extra environment variables set for every command
+	Env []string
+	// only validate resources client side instead of applying them
+	DryRun bool
+}
+
+// Command returns a command with the environment set up to talk to the client's cluster
+func (c Client) Command(name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), c.Env...)
+
+	if c.Kubeconfig != "" {
+		cmd.Env = append(cmd.Env, "KUBECONFIG="+c.Kubeconfig)
+	}
+
+	return cmd
+}
+
+// Kubectl returns a kubectl command using the client's context
+func (c Client) Kubectl(args ...string) *exec.Cmd {
+	if c.Context != "" {
+		args = append([]string{"--context", c.Context}, args...)
+	}
+
+	return c.Command("kubectl", args...)
+}
+
+// Helm returns a helm command using the client's context
+func (c Client) Helm(args ...string) *exec.Cmd {
+	if c.Context != "" {
+		args = append([]string{"--kube-context", c.Context}, args...)
+	}
+
+	return c.Command("helm", args...)
+}
+
+func (c Client) ApplyResource(resource interface{}) error {
 	manifest, err := json.Marshal(resource)
 	if err != nil {
 		return fmt.Errorf("failed to marshal resource: %w", err)
 	}
 
-	cmd := exec.Command("kubectl", "apply", "--wait", "-f", "-")
+	args := []string{"apply", "--wait", "-f", "-"}
+	if c.DryRun {
+		args = append(args, "--dry-run=client")
+	}
+
+	cmd := c.Kubectl(args...)
 
 	cmd.Stdin = bytes.NewReader(manifest)
 	cmd.Stdout = os.Stdout
@@ -27,3 +74,60 @@ func ApplyResource(resource interface{}) error {
 
 	return cmd.Wait()
 }
+
+func ApplyResource(resource interface{}) error {
+	return Client{}.ApplyResource(resource)
+}
+
+// GetResource gets a single resource and unmarshals it into out
+func (c Client) GetResource(kind string, namespace string, name string, out interface{}) error {
+	args := []string{"get", kind, name, "-o", "json"}
+	if namespace != "" {
+		args = append(args, "-n", namespace)
+	}
+
+	var stderr bytes.Buffer
+
+	cmd := c.Kubectl(args...)
+	cmd.Stderr = &stderr
+
+	b, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("failed to get %s %s: %w: %s", kind, name, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	return json.Unmarshal(b, out)
+}
+
+// ListResources lists resources of kind in namespace that match the label selector and unmarshals the list into out
+func (c Client) ListResources(kind string, namespace string, selector string, out interface{}) error {
+	var stderr bytes.Buffer
+
+	cmd := c.Kubectl("get", kind, "-n", namespace, "-l", selector, "-o", "json")
+	cmd.Stderr = &stderr
+
+	b, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w: %s", kind, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	return json.Unmarshal(b, out)
+}
+
+// DeleteResource deletes a single resource
+func (c Client) DeleteResource(kind string, namespace string, name string) error {
+	args := []string{"delete", kind, name, "--ignore-not-found"}
+	if namespace != "" {
+		args = append(args, "-n", namespace)
+	}
+
+	if c.DryRun {
+		args = append(args, "--dry-run=client")
+	}
+
+	cmd := c.Kubectl(args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
@@ -0,0 +1,224 @@
+package sealedsecret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Scope controls where a sealed secret can be unsealed, it matches the scopes of kubeseal
+type Scope string
+
+const (
+	// ScopeStrict only allows the secret to be unsealed with the same name and namespace
+	ScopeStrict Scope = "strict"
+	// ScopeNamespaceWide allows the secret to be renamed within its namespace
+	ScopeNamespaceWide Scope = "namespace-wide"
+	// ScopeClusterWide allows the secret to be unsealed with any name in any namespace
+	ScopeClusterWide Scope = "cluster-wide"
+)
+
+const (
+	namespaceWideAnnotation = "sealedsecrets.bitnami.com/namespace-wide"
+	clusterWideAnnotation   = "sealedsecrets.bitnami.com/cluster-wide"
+	sessionKeyBytes         = 32
+)
+
+// Sealer encrypts secrets offline with the sealed secrets controller's public cert
+type Sealer struct {
+	key   *rsa.PublicKey
+	scope Scope
+}
+
+// NewSealerFromFile reads a PEM encoded cert, as printed by kubeseal --fetch-cert
+func NewSealerFromFile(certPath string, scope Scope) (*Sealer, error) {
+	b, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sealed secrets cert: %w", err)
+	}
+
+	return NewSealer(b, scope)
+}
+
+// NewSealer parses a PEM encoded cert or RSA public key. scope defaults to ScopeStrict
+func NewSealer(certPEM []byte, scope Scope) (*Sealer, error) {
+	if scope == "" {
+		scope = ScopeStrict
+	}
+
+	switch scope {
+	case ScopeStrict, ScopeNamespaceWide, ScopeClusterWide:
+	default:
+		return nil, fmt.Errorf("invalid sealed secret scope %s, expected one of %s, %s or %s", scope, ScopeStrict, ScopeNamespaceWide, ScopeClusterWide)
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, errors.New("sealed secrets cert is not PEM encoded")
+	}
+
+	var pub interface{}
+
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse sealed secrets cert: %w", err)
+		}
+
+		pub = cert.PublicKey
+	default:
+		var err error
+
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse sealed secrets public key: %w", err)
+		}
+	}
+
+	key, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("sealed secrets cert does not contain an RSA public key")
+	}
+
+	return &Sealer{key: key, scope: scope}, nil
+}
+
+// Seal returns a SealedSecret manifest with every value in secret encrypted
+func (s *Sealer) Seal(secret v1.Secret) (map[string]interface{}, error) {
+	label := s.label(secret.Namespace, secret.Name)
+	encryptedData := make(map[string]interface{}, len(secret.Data))
+
+	for k, v := range secret.Data {
+		ciphertext, err := hybridEncrypt(rand.Reader, s.key, v, label)
+		if err != nil {
+			return nil, fmt.Errorf("failed to seal %s in secret %s: %w", k, secret.Name, err)
+		}
+
+		encryptedData[k] = base64.StdEncoding.EncodeToString(ciphertext)
+	}
+
+	for k, v := range secret.StringData {
+		ciphertext, err := hybridEncrypt(rand.Reader, s.key, []byte(v), label)
+		if err != nil {
+			return nil, fmt.Errorf("failed to seal %s in secret %s: %w", k, secret.Name, err)
+		}
+
+		encryptedData[k] = base64.StdEncoding.EncodeToString(ciphertext)
+	}
+
+	annotations := map[string]interface{}{}
+	for k, v := range secret.Annotations {
+		annotations[k] = v
+	}
+
+	switch s.scope {
+	case ScopeNamespaceWide:
+		annotations[namespaceWideAnnotation] = "true"
+	case ScopeClusterWide:
+		annotations[clusterWideAnnotation] = "true"
+	}
+
+	templateMetadata := map[string]interface{}{
+		"name":      secret.Name,
+		"namespace": secret.Namespace,
+	}
+
+	if len(secret.Labels) != 0 {
+		templateMetadata["labels"] = secret.Labels
+	}
+
+	if len(secret.Annotations) != 0 {
+		templateMetadata["annotations"] = secret.Annotations
+	}
+
+	metadata := map[string]interface{}{
+		"name":      secret.Name,
+		"namespace": secret.Namespace,
+	}
+
+	if len(secret.Labels) != 0 {
+		metadata["labels"] = secret.Labels
+	}
+
+	if len(annotations) != 0 {
+		metadata["annotations"] = annotations
+	}
+
+	template := map[string]interface{}{
+		"metadata": templateMetadata,
+	}
+
+	if secret.Type != "" {
+		template["type"] = string(secret.Type)
+	}
+
+	return map[string]interface{}{
+		"apiVersion": "bitnami.com/v1alpha1",
+		"kind":       "SealedSecret",
+		"metadata":   metadata,
+		"spec": map[string]interface{}{
+			"encryptedData": encryptedData,
+			"template":      template,
+		},
+	}, nil
+}
+
+// label binds the ciphertext to the secret's name and namespace depending on the scope, the controller uses the same label to decrypt
+func (s *Sealer) label(namespace string, name string) []byte {
+	switch s.scope {
+	case ScopeClusterWide:
+		return []byte{}
+	case ScopeNamespaceWide:
+		return []byte(namespace)
+	}
+
+	return []byte(fmt.Sprintf("%s/%s", namespace, name))
+}
+
+// hybridEncrypt matches the sealed secrets controller's format: a random AES-256 session key is encrypted with RSA-OAEP,
+// prefixed with its 2 byte length, followed by the plaintext encrypted with AES-GCM using the session key and a zero nonce.
+// the nonce can be zero because every session key is only used once
+func hybridEncrypt(rnd io.Reader, pubKey *rsa.PublicKey, plaintext []byte, label []byte) ([]byte, error) {
+	sessionKey := make([]byte, sessionKeyBytes)
+
+	_, err := io.ReadFull(rnd, sessionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aed, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaCiphertext, err := rsa.EncryptOAEP(sha256.New(), rnd, pubKey, sessionKey, label)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext := make([]byte, 2, 2+len(rsaCiphertext)+len(plaintext)+aed.Overhead())
+	binary.BigEndian.PutUint16(ciphertext, uint16(len(rsaCiphertext)))
+	ciphertext = append(ciphertext, rsaCiphertext...)
+
+	zeroNonce := make([]byte, aed.NonceSize())
+
+	return aed.Seal(ciphertext, zeroNonce, plaintext, nil), nil
+}
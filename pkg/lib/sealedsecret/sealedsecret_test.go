@@ -0,0 +1,139 @@
+package sealedsecret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// hybridDecrypt is how the sealed secrets controller unseals a value
+func hybridDecrypt(privKey *rsa.PrivateKey, ciphertext []byte, label []byte) ([]byte, error) {
+	rsaLen := int(binary.BigEndian.Uint16(ciphertext))
+	rsaCiphertext := ciphertext[2 : 2+rsaLen]
+
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), nil, privKey, rsaCiphertext, label)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aed, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return aed.Open(nil, make([]byte, aed.NonceSize()), ciphertext[2+rsaLen:], nil)
+}
+
+func testCert(t *testing.T) (*rsa.PrivateKey, []byte) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sealed-secret"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestSealRoundTrip(t *testing.T) {
+	key, cert := testCert(t)
+
+	secret := v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "web"},
+		Data:       map[string][]byte{"password": []byte("s3cret")},
+		StringData: map[string]string{"user": "admin"},
+	}
+
+	tests := []struct {
+		scope      Scope
+		label      string
+		otherLabel string
+		annotation string
+	}{
+		{scope: ScopeStrict, label: "web/db", otherLabel: "web/other"},
+		{scope: ScopeNamespaceWide, label: "web", otherLabel: "other", annotation: namespaceWideAnnotation},
+		{scope: ScopeClusterWide, label: "", otherLabel: "web/db", annotation: clusterWideAnnotation},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.scope), func(t *testing.T) {
+			sealer, err := NewSealer(cert, tt.scope)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sealed, err := sealer.Seal(secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			encryptedData := sealed["spec"].(map[string]interface{})["encryptedData"].(map[string]interface{})
+
+			for k, expected := range map[string]string{"password": "s3cret", "user": "admin"} {
+				ciphertext, err := base64.StdEncoding.DecodeString(encryptedData[k].(string))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				plaintext, err := hybridDecrypt(key, ciphertext, []byte(tt.label))
+				if err != nil {
+					t.Fatalf("failed to unseal %s: %s", k, err)
+				}
+
+				if string(plaintext) != expected {
+					t.Errorf("expected %s to be %q, got %q", k, expected, plaintext)
+				}
+
+				if _, err := hybridDecrypt(key, ciphertext, []byte(tt.otherLabel)); err == nil {
+					t.Errorf("expected %s not to unseal with label %q", k, tt.otherLabel)
+				}
+			}
+
+			annotations, _ := sealed["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})
+
+			for _, a := range []string{namespaceWideAnnotation, clusterWideAnnotation} {
+				if _, ok := annotations[a]; ok != (a == tt.annotation) {
+					t.Errorf("unexpected annotations for scope %s: %v", tt.scope, annotations)
+				}
+			}
+		})
+	}
+}
+
+func TestNewSealerInvalidScope(t *testing.T) {
+	_, cert := testCert(t)
+
+	if _, err := NewSealer(cert, Scope("global")); err == nil {
+		t.Error("expected an error for an invalid scope")
+	}
+}
@@ -0,0 +1,52 @@
+package secretprovider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Files creates a secret from plain files, the files are expected to be protected some other way like git-crypt
+type Files struct{}
+
+type filesConfig struct {
+	// secret keys mapped to files relative to the config folder
+	Files map[string]string `json:"files"`
+	Type  v1.SecretType     `json:"type"`
+}
+
+func (Files) Name() string {
+	return "files"
+}
+
+func (Files) Secrets(source Source) ([]v1.Secret, error) {
+	config := filesConfig{}
+
+	err := json.Unmarshal(source.Config, &config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid files secret config: %w", err)
+	}
+
+	if source.Name == "" {
+		return nil, fmt.Errorf("%w: name can not be blank", ErrInvalidSecret)
+	}
+
+	secret := NewSecret(source.Name, source.Namespace)
+	if config.Type != "" {
+		secret.Type = config.Type
+	}
+
+	for key, file := range config.Files {
+		b, err := ioutil.ReadFile(filepath.Join(source.Dir, file))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s for secret %s: %w", file, source.Name, err)
+		}
+
+		secret.Data[key] = b
+	}
+
+	return []v1.Secret{secret}, nil
+}
@@ -0,0 +1,46 @@
+package secretprovider
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+// Memory returns secrets it was created with, it is meant for tests
+type Memory struct {
+	ProviderName string
+	// secrets keyed by source path for files or by name for declarations
+	Sources map[string][]v1.Secret
+	// errors returned instead of secrets, keyed the same way as Sources
+	Errors map[string]error
+}
+
+func (m Memory) Name() string {
+	if m.ProviderName == "" {
+		return "memory"
+	}
+
+	return m.ProviderName
+}
+
+func (m Memory) Secrets(source Source) ([]v1.Secret, error) {
+	key := source.Path
+	if key == "" {
+		key = source.Name
+	}
+
+	if err := m.Errors[key]; err != nil {
+		return nil, err
+	}
+
+	secrets := make([]v1.Secret, 0, len(m.Sources[key]))
+
+	for _, s := range m.Sources[key] {
+		s = *s.DeepCopy()
+		if s.Namespace == "" {
+			s.Namespace = source.Namespace
+		}
+
+		secrets = append(secrets, s)
+	}
+
+	return secrets, nil
+}
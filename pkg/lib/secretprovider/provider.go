@@ -0,0 +1,260 @@
+package secretprovider
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ErrInvalidSecret is returned for sources that aren't valid secrets, they are skipped instead of failing the deploy
+var ErrInvalidSecret = errors.New("secret is invalid")
+
+// SecretProvider decrypts or fetches secrets from a source
+type SecretProvider interface {
+	// Name identifies the provider in logs and metadata.yml declarations
+	Name() string
+	// Secrets returns the decrypted secrets for the source. Secrets without a namespace are put in source.Namespace
+	Secrets(source Source) ([]v1.Secret, error)
+}
+
+// Matcher is implemented by providers that detect their files by content instead of file extension
+type Matcher interface {
+	Match(path string, content []byte) bool
+}
+
+// ManifestProvider is implemented by providers that can also decrypt manifests that aren't secrets
+type ManifestProvider interface {
+	// SecretsAndManifests returns the decrypted secrets and the decrypted manifests that aren't secrets, which are applied as is.
+	// both come from decrypting the source once
+	SecretsAndManifests(source Source) ([]v1.Secret, []map[string]interface{}, error)
+}
+
+// Decrypt returns the secrets and, for manifest providers, the other manifests from the source
+func Decrypt(provider SecretProvider, source Source) ([]v1.Secret, []map[string]interface{}, error) {
+	if p, ok := provider.(ManifestProvider); ok {
+		return p.SecretsAndManifests(source)
+	}
+
+	secrets, err := provider.Secrets(source)
+
+	return secrets, nil, err
+}
+
+type Source struct {
+	// path of the file the secrets come from, empty for declared secrets
+	Path string
+	// content of the file, empty for declared secrets
+	Content []byte
+	// directory relative paths in the declaration config are resolved from
+	Dir string
+	// default namespace for the secrets
+	Namespace string
+	// name of the secret for declared secrets
+	Name string
+	// provider specific config from the metadata.yml declaration
+	Config json.RawMessage
+}
+
+// Registry finds the provider for a file or a metadata.yml declaration
+type Registry struct {
+	byExtension map[string]SecretProvider
+	byName      map[string]SecretProvider
+	matchers    []SecretProvider
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		byExtension: make(map[string]SecretProvider),
+		byName:      make(map[string]SecretProvider),
+	}
+}
+
+// Register makes the provider available to metadata.yml declarations and to files it matches if it is a Matcher
+func (r *Registry) Register(p SecretProvider) {
+	r.byName[p.Name()] = p
+
+	if _, ok := p.(Matcher); ok {
+		r.matchers = append(r.matchers, p)
+	}
+}
+
+// RegisterExtension registers the provider and uses it for every file with the extension
+func (r *Registry) RegisterExtension(ext string, p SecretProvider) {
+	r.Register(p)
+	r.byExtension[ext] = p
+}
+
+// ForFile returns the provider for a file or nil if the file isn't a secret
+func (r *Registry) ForFile(path string, content []byte) SecretProvider {
+	if p, ok := r.byExtension[filepath.Ext(path)]; ok {
+		return p
+	}
+
+	for _, p := range r.matchers {
+		if p.(Matcher).Match(path, content) {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// ForName returns the provider registered with the name
+func (r *Registry) ForName(name string) (SecretProvider, error) {
+	if p, ok := r.byName[name]; ok {
+		return p, nil
+	}
+
+	return nil, fmt.Errorf("unknown secret provider %s", name)
+}
+
+// document is the _name, _namespace and data shape used by ejson and sops secrets
+type document struct {
+	Name        string            `json:"_name"`
+	Namespace   string            `json:"_namespace"`
+	Type        string            `json:"_type"`
+	Labels      map[string]string `json:"_labels"`
+	Annotations map[string]string `json:"_annotations"`
+	// values are used as is like stringData in a secret manifest, values that aren't strings are stored as json
+	Data map[string]interface{} `json:"data"`
+	// same as data, matches the secret manifest field name
+	StringData map[string]interface{} `json:"stringData"`
+	// base64 encoded values for binary data like keystores
+	Base64Data map[string]string `json:"base64Data"`
+}
+
+// secretTypeAliases are short names that can be used for _type
+var secretTypeAliases = map[string]v1.SecretType{
+	"opaque":           v1.SecretTypeOpaque,
+	"tls":              v1.SecretTypeTLS,
+	"dockerconfigjson": v1.SecretTypeDockerConfigJson,
+	"dockercfg":        v1.SecretTypeDockercfg,
+	"basic-auth":       v1.SecretTypeBasicAuth,
+	"ssh-auth":         v1.SecretTypeSSHAuth,
+}
+
+// requiredSecretKeys are the keys kubernetes requires for typed secrets
+var requiredSecretKeys = map[v1.SecretType][]string{
+	v1.SecretTypeTLS:              {v1.TLSCertKey, v1.TLSPrivateKeyKey},
+	v1.SecretTypeDockerConfigJson: {v1.DockerConfigJsonKey},
+	v1.SecretTypeDockercfg:        {v1.DockerConfigKey},
+	v1.SecretTypeSSHAuth:          {v1.SSHAuthPrivateKey},
+}
+
+// CheckDocument checks that a document, which can still be encrypted, is a secret document
+func CheckDocument(content []byte) error {
+	var doc document
+
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return fmt.Errorf("Failed to unmarshal json file %w", err)
+	}
+
+	if doc.Name == "" {
+		return fmt.Errorf("%w: _name can not be blank", ErrInvalidSecret)
+	}
+
+	return nil
+}
+
+// SecretFromDocument converts a decrypted json document with _name, _namespace and data keys to a secret.
+// _type, _labels and _annotations set the secret type and metadata
+func SecretFromDocument(decrypted []byte, namespace string) (v1.Secret, error) {
+	var doc document
+
+	if err := json.Unmarshal(decrypted, &doc); err != nil {
+		return v1.Secret{}, fmt.Errorf("Failed to unmarshal decrypted json file %w", err)
+	}
+
+	if doc.Name == "" {
+		return v1.Secret{}, fmt.Errorf("%w: _name can not be blank", ErrInvalidSecret)
+	}
+
+	// set namespace to default value if no namespace is set in the secret
+	if doc.Namespace == "" {
+		doc.Namespace = namespace
+	}
+
+	secret := NewSecret(doc.Name, doc.Namespace)
+	secret.Labels = doc.Labels
+	secret.Annotations = doc.Annotations
+
+	if doc.Type != "" {
+		secret.Type = v1.SecretType(doc.Type)
+		if alias, ok := secretTypeAliases[strings.ToLower(doc.Type)]; ok {
+			secret.Type = alias
+		}
+	}
+
+	// convert secrets to base64
+	for _, data := range []map[string]interface{}{doc.Data, doc.StringData} {
+		for key, value := range data {
+			var bytes []byte
+			if s, ok := value.(string); ok {
+				bytes = []byte(s)
+			} else {
+				bytes, _ = json.Marshal(value)
+			}
+
+			secret.Data[key] = bytes
+		}
+	}
+
+	for key, value := range doc.Base64Data {
+		b, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return v1.Secret{}, fmt.Errorf("base64Data %s in secret %s is not valid base64: %w", key, doc.Name, err)
+		}
+
+		secret.Data[key] = b
+	}
+
+	return secret, ValidateSecret(secret)
+}
+
+// ValidateSecret checks that typed secrets have the keys kubernetes requires for their type
+func ValidateSecret(secret v1.Secret) error {
+	missing := []string{}
+
+	for _, key := range requiredSecretKeys[secret.Type] {
+		if _, ok := secret.Data[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+
+	if len(missing) != 0 {
+		return fmt.Errorf("secret %s of type %s is missing required keys: %s", secret.Name, secret.Type, strings.Join(missing, ", "))
+	}
+
+	if secret.Type == v1.SecretTypeBasicAuth {
+		_, hasUsername := secret.Data[v1.BasicAuthUsernameKey]
+		_, hasPassword := secret.Data[v1.BasicAuthPasswordKey]
+
+		if !hasUsername && !hasPassword {
+			return fmt.Errorf("secret %s of type %s needs a %s or %s key", secret.Name, secret.Type, v1.BasicAuthUsernameKey, v1.BasicAuthPasswordKey)
+		}
+	}
+
+	return nil
+}
+
+// NewSecret returns an empty opaque secret
+func NewSecret(name string, namespace string) v1.Secret {
+	return v1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Data: make(map[string][]byte),
+		Type: v1.SecretTypeOpaque,
+	}
+}
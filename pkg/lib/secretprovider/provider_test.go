@@ -0,0 +1,103 @@
+package secretprovider
+
+import (
+	"errors"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestRegistryForFile(t *testing.T) {
+	memory := Memory{}
+	files := Files{}
+
+	registry := NewRegistry()
+	registry.RegisterExtension(".mem", memory)
+	registry.Register(files)
+
+	if p := registry.ForFile("app/db.mem", nil); p == nil || p.Name() != "memory" {
+		t.Errorf("expected the memory provider for .mem files, got %v", p)
+	}
+
+	if p := registry.ForFile("app/deployment.yaml", []byte("kind: Deployment")); p != nil {
+		t.Errorf("expected no provider for a plain manifest, got %s", p.Name())
+	}
+
+	if p, err := registry.ForName("memory"); err != nil || p.Name() != "memory" {
+		t.Errorf("expected the memory provider by name, got %v %v", p, err)
+	}
+
+	if _, err := registry.ForName("missing"); err == nil {
+		t.Error("expected an error for an unknown provider")
+	}
+}
+
+func TestMemorySecrets(t *testing.T) {
+	memory := Memory{
+		Sources: map[string][]v1.Secret{
+			"app/db.mem": {NewSecret("db", ""), NewSecret("cache", "other")},
+		},
+		Errors: map[string]error{
+			"app/bad.mem": ErrInvalidSecret,
+		},
+	}
+
+	secrets, manifests, err := Decrypt(memory, Source{Path: "app/db.mem", Namespace: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(manifests) != 0 {
+		t.Errorf("expected no manifests from the memory provider, got %d", len(manifests))
+	}
+
+	if len(secrets) != 2 || secrets[0].Namespace != "app" || secrets[1].Namespace != "other" {
+		t.Errorf("expected secrets in the source namespace unless they set one, got %+v", secrets)
+	}
+
+	// secrets are copied so callers can label them
+	secrets[0].Labels = map[string]string{"a": "b"}
+
+	if memory.Sources["app/db.mem"][0].Labels != nil {
+		t.Error("labelling a returned secret modified the provider's secret")
+	}
+
+	_, _, err = Decrypt(memory, Source{Path: "app/bad.mem"})
+	if !errors.Is(err, ErrInvalidSecret) {
+		t.Errorf("expected ErrInvalidSecret, got %v", err)
+	}
+}
+
+func TestSecretFromDocument(t *testing.T) {
+	doc := `{
+		"_name": "tls",
+		"_type": "tls",
+		"data": {"tls.crt": "cert", "port": 443},
+		"base64Data": {"tls.key": "a2V5"}
+	}`
+
+	secret, err := SecretFromDocument([]byte(doc), "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if secret.Namespace != "app" || secret.Type != v1.SecretTypeTLS {
+		t.Errorf("unexpected secret metadata %s %s", secret.Namespace, secret.Type)
+	}
+
+	for key, expected := range map[string]string{"tls.crt": "cert", "tls.key": "key", "port": "443"} {
+		if string(secret.Data[key]) != expected {
+			t.Errorf("expected %s to be %q, got %q", key, expected, secret.Data[key])
+		}
+	}
+
+	_, err = SecretFromDocument([]byte(`{"_name": "tls", "_type": "tls", "data": {"tls.crt": "cert"}}`), "app")
+	if err == nil {
+		t.Error("expected an error for a tls secret without tls.key")
+	}
+
+	_, err = SecretFromDocument([]byte(`{"data": {"a": "b"}}`), "app")
+	if !errors.Is(err, ErrInvalidSecret) {
+		t.Errorf("expected ErrInvalidSecret for a document without _name, got %v", err)
+	}
+}
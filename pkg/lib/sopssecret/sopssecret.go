@@ -0,0 +1,140 @@
+package sopssecret
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/bcaldwell/kube-deploy/pkg/lib/secretprovider"
+	"github.com/ghodss/yaml"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Provider decrypts sops encrypted yaml and json files. Secret manifests and documents with the ejson
+// _name, _namespace and data shape become secrets, any other manifest is applied as is
+type Provider struct{}
+
+func (Provider) Name() string {
+	return "sops"
+}
+
+func (Provider) Match(path string, content []byte) bool {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml", ".json":
+		return IsEncrypted(content)
+	}
+
+	return false
+}
+
+func (p Provider) Secrets(source secretprovider.Source) ([]v1.Secret, error) {
+	secrets, _, err := p.SecretsAndManifests(source)
+	return secrets, err
+}
+
+// SecretsAndManifests decrypts the file once and returns the secret or the manifest it contains
+func (p Provider) SecretsAndManifests(source secretprovider.Source) ([]v1.Secret, []map[string]interface{}, error) {
+	manifest, decrypted, err := decryptSource(source)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kind, _ := manifest["kind"].(string)
+
+	switch kind {
+	case "":
+		secret, err := secretprovider.SecretFromDocument(decrypted, source.Namespace)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return []v1.Secret{secret}, nil, nil
+	case "Secret":
+		secret := v1.Secret{}
+
+		err = json.Unmarshal(decrypted, &secret)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to unmarshal decrypted secret %w", err)
+		}
+
+		if secret.Namespace == "" {
+			secret.Namespace = source.Namespace
+		}
+
+		return []v1.Secret{secret}, nil, nil
+	}
+
+	metadata, _ := manifest["metadata"].(map[string]interface{})
+	if metadata != nil && metadata["namespace"] == nil && source.Namespace != "" {
+		metadata["namespace"] = source.Namespace
+	}
+
+	return nil, []map[string]interface{}{manifest}, nil
+}
+
+func decryptSource(source secretprovider.Source) (map[string]interface{}, []byte, error) {
+	encryptedFile := source.Content
+	if encryptedFile == nil {
+		var err error
+
+		encryptedFile, err = ioutil.ReadFile(source.Path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %w", source.Path, err)
+		}
+	}
+
+	inputType := "yaml"
+	if filepath.Ext(source.Path) == ".json" {
+		inputType = "json"
+	}
+
+	decrypted, err := Decrypt(encryptedFile, inputType)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decrypt %s: %w", source.Path, err)
+	}
+
+	manifest := map[string]interface{}{}
+
+	err = json.Unmarshal(decrypted, &manifest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal decrypted sops file %w", err)
+	}
+
+	return manifest, decrypted, nil
+}
+
+// IsEncrypted reports whether a yaml or json document was encrypted by sops
+func IsEncrypted(content []byte) bool {
+	doc := struct {
+		Sops *struct {
+			Mac string `json:"mac"`
+		} `json:"sops"`
+	}{}
+
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return false
+	}
+
+	return doc.Sops != nil && doc.Sops.Mac != ""
+}
+
+// Decrypt decrypts a sops document in memory and returns it as json.
+// sops finds age keys itself using SOPS_AGE_KEY, SOPS_AGE_KEY_FILE or ~/.config/sops/age/keys.txt
+func Decrypt(content []byte, inputType string) ([]byte, error) {
+	cmd := exec.Command("sops", "--decrypt", "--input-type", inputType, "--output-type", "json", "/dev/stdin")
+
+	var stderr bytes.Buffer
+
+	cmd.Stdin = bytes.NewReader(content)
+	cmd.Stderr = &stderr
+
+	decrypted, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("sops failed to decrypt: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	return decrypted, nil
+}
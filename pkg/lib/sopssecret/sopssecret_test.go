@@ -0,0 +1,85 @@
+package sopssecret
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bcaldwell/kube-deploy/pkg/lib/secretprovider"
+)
+
+// fakeSops puts a sops on the PATH that prints decrypted and counts how often it runs
+func fakeSops(t *testing.T, decrypted string) (calls func() int, cleanup func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "sops")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := filepath.Join(dir, "count")
+	script := "#!/bin/sh\necho x >> " + count + "\ncat <<'EOF'\n" + decrypted + "\nEOF\n"
+
+	err = ioutil.WriteFile(filepath.Join(dir, "sops"), []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+
+	calls = func() int {
+		b, _ := ioutil.ReadFile(count)
+		return strings.Count(string(b), "x")
+	}
+
+	return calls, func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSecretsAndManifestsDecryptsOnce(t *testing.T) {
+	calls, cleanup := fakeSops(t, `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "cm"}}`)
+	defer cleanup()
+
+	source := secretprovider.Source{Path: "cm.yaml", Content: []byte("encrypted"), Namespace: "web"}
+
+	secrets, manifests, err := secretprovider.Decrypt(Provider{}, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls() != 1 {
+		t.Errorf("expected sops to run once, it ran %d times", calls())
+	}
+
+	if len(secrets) != 0 || len(manifests) != 1 {
+		t.Fatalf("expected a single manifest, got %d secrets and %d manifests", len(secrets), len(manifests))
+	}
+
+	metadata := manifests[0]["metadata"].(map[string]interface{})
+	if metadata["namespace"] != "web" {
+		t.Errorf("expected the manifest to default to the source namespace, got %v", metadata["namespace"])
+	}
+}
+
+func TestSecretsAndManifestsDocument(t *testing.T) {
+	_, cleanup := fakeSops(t, `{"_name": "db", "data": {"password": "hunter2"}}`)
+	defer cleanup()
+
+	secrets, manifests, err := Provider{}.SecretsAndManifests(secretprovider.Source{Path: "db.json", Content: []byte("encrypted"), Namespace: "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(manifests) != 0 || len(secrets) != 1 {
+		t.Fatalf("expected a single secret, got %d secrets and %d manifests", len(secrets), len(manifests))
+	}
+
+	if secrets[0].Name != "db" || secrets[0].Namespace != "web" || string(secrets[0].Data["password"]) != "hunter2" {
+		t.Errorf("unexpected secret %+v", secrets[0])
+	}
+}
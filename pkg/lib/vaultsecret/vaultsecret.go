@@ -0,0 +1,319 @@
+package vaultsecret
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bcaldwell/kube-deploy/pkg/lib/logger"
+	"github.com/bcaldwell/kube-deploy/pkg/lib/secretprovider"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	AuthToken      = "token"
+	AuthAppRole    = "approle"
+	AuthKubernetes = "kubernetes"
+)
+
+type Config struct {
+	// vault address, defaults to VAULT_ADDR
+	Address string
+	// vault enterprise namespace, defaults to VAULT_NAMESPACE
+	Namespace string
+	Auth      AuthConfig
+}
+
+type AuthConfig struct {
+	// token, approle or kubernetes. When empty token is used if VAULT_TOKEN is set, then kubernetes when running in cluster, then approle
+	Method string
+	// path the auth method is mounted at, defaults to the method name
+	Mount string
+	// vault role for kubernetes auth
+	Role string
+	// approle role id, defaults to VAULT_ROLE_ID
+	RoleID string `json:"roleID"`
+	// env variable holding the approle secret id, defaults to VAULT_SECRET_ID
+	SecretIDEnv string `json:"secretIDEnv"`
+	// service account token used for kubernetes auth
+	ServiceAccountTokenPath string `json:"serviceAccountTokenPath"`
+}
+
+// secretConfig is the config of a vault secret declaration in metadata.yml
+type secretConfig struct {
+	// kv secrets engine mount, defaults to secret
+	Mount string
+	// path of the secret inside of the mount
+	Path string
+	// kv version, defaults to 2
+	Version int
+	// kubernetes secret keys mapped to vault keys, every key is copied when empty
+	Keys map[string]string
+	Type v1.SecretType
+}
+
+// Provider reads secrets from a vault kv secrets engine
+type Provider struct {
+	Config     Config
+	HTTPClient *http.Client
+
+	mu    sync.Mutex
+	token string
+}
+
+func New(config Config) *Provider {
+	if config.Address == "" {
+		config.Address = os.Getenv("VAULT_ADDR")
+	}
+
+	if config.Namespace == "" {
+		config.Namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+
+	return &Provider{
+		Config:     config,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (p *Provider) Name() string {
+	return "vault"
+}
+
+func (p *Provider) Secrets(source secretprovider.Source) ([]v1.Secret, error) {
+	config := secretConfig{}
+
+	err := json.Unmarshal(source.Config, &config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vault secret config: %w", err)
+	}
+
+	if source.Name == "" || config.Path == "" {
+		return nil, fmt.Errorf("%w: vault secrets need a name and path", secretprovider.ErrInvalidSecret)
+	}
+
+	data, err := p.read(config)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := secretprovider.NewSecret(source.Name, source.Namespace)
+	if config.Type != "" {
+		secret.Type = config.Type
+	}
+
+	keys := config.Keys
+	if len(keys) == 0 {
+		keys = make(map[string]string, len(data))
+		for k := range data {
+			keys[k] = k
+		}
+	}
+
+	for secretKey, vaultKey := range keys {
+		value, ok := data[vaultKey]
+		if !ok {
+			return nil, fmt.Errorf("key %s not found in vault secret %s", vaultKey, config.Path)
+		}
+
+		if s, ok := value.(string); ok {
+			secret.Data[secretKey] = []byte(s)
+		} else {
+			secret.Data[secretKey], _ = json.Marshal(value)
+		}
+	}
+
+	return []v1.Secret{secret}, nil
+}
+
+func (p *Provider) read(config secretConfig) (map[string]interface{}, error) {
+	mount := strings.Trim(config.Mount, "/")
+	if mount == "" {
+		mount = "secret"
+	}
+
+	secretPath := strings.Trim(config.Path, "/")
+
+	apiPath := fmt.Sprintf("%s/data/%s", mount, secretPath)
+	if config.Version == 1 {
+		apiPath = fmt.Sprintf("%s/%s", mount, secretPath)
+	}
+
+	token, err := p.login()
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Log("reading vault secret %s", apiPath)
+
+	resp := struct {
+		Data map[string]interface{} `json:"data"`
+	}{}
+
+	err = p.do(http.MethodGet, apiPath, token, nil, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vault secret %s: %w", apiPath, err)
+	}
+
+	if config.Version == 1 {
+		return resp.Data, nil
+	}
+
+	data, _ := resp.Data["data"].(map[string]interface{})
+	if data == nil {
+		return nil, fmt.Errorf("vault secret %s has no data", apiPath)
+	}
+
+	return data, nil
+}
+
+// login returns a vault token, logging in with the configured auth method the first time
+func (p *Provider) login() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.token != "" {
+		return p.token, nil
+	}
+
+	auth := p.Config.Auth
+	method := auth.Method
+
+	if method == "" {
+		switch {
+		case os.Getenv("VAULT_TOKEN") != "":
+			method = AuthToken
+		case auth.ServiceAccountTokenPath != "" && fileExists(auth.ServiceAccountTokenPath):
+			method = AuthKubernetes
+		default:
+			method = AuthAppRole
+		}
+	}
+
+	mount := strings.Trim(auth.Mount, "/")
+	if mount == "" {
+		mount = method
+	}
+
+	var body map[string]string
+
+	switch method {
+	case AuthToken:
+		p.token = os.Getenv("VAULT_TOKEN")
+		if p.token == "" {
+			return "", fmt.Errorf("vault token auth requires VAULT_TOKEN to be set")
+		}
+
+		return p.token, nil
+	case AuthAppRole:
+		roleID := auth.RoleID
+		if roleID == "" {
+			roleID = os.Getenv("VAULT_ROLE_ID")
+		}
+
+		secretIDEnv := auth.SecretIDEnv
+		if secretIDEnv == "" {
+			secretIDEnv = "VAULT_SECRET_ID"
+		}
+
+		if roleID == "" || os.Getenv(secretIDEnv) == "" {
+			return "", fmt.Errorf("vault approle auth requires a role id and %s to be set", secretIDEnv)
+		}
+
+		body = map[string]string{
+			"role_id":   roleID,
+			"secret_id": os.Getenv(secretIDEnv),
+		}
+	case AuthKubernetes:
+		jwt, err := ioutil.ReadFile(auth.ServiceAccountTokenPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read service account token for vault kubernetes auth: %w", err)
+		}
+
+		body = map[string]string{
+			"role": auth.Role,
+			"jwt":  strings.TrimSpace(string(jwt)),
+		}
+	default:
+		return "", fmt.Errorf("unknown vault auth method %s", method)
+	}
+
+	logger.Log("logging into vault at %s using %s auth", p.Config.Address, method)
+
+	resp := struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}{}
+
+	err := p.do(http.MethodPost, fmt.Sprintf("auth/%s/login", mount), "", body, &resp)
+	if err != nil {
+		return "", fmt.Errorf("failed to login to vault with %s auth: %w", method, err)
+	}
+
+	p.token = resp.Auth.ClientToken
+
+	return p.token, nil
+}
+
+func (p *Provider) do(method string, apiPath string, token string, body interface{}, out interface{}) error {
+	if p.Config.Address == "" {
+		return fmt.Errorf("vault address is not set, set vault.address or VAULT_ADDR")
+	}
+
+	var reqBody bytes.Buffer
+
+	if body != nil {
+		err := json.NewEncoder(&reqBody).Encode(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(p.Config.Address, "/")+"/v1/"+apiPath, &reqBody)
+	if err != nil {
+		return err
+	}
+
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+
+	if p.Config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.Config.Namespace)
+	}
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		errResp := struct {
+			Errors []string `json:"errors"`
+		}{}
+		_ = json.Unmarshal(b, &errResp)
+
+		return fmt.Errorf("vault returned %s: %s", resp.Status, strings.Join(errResp.Errors, ", "))
+	}
+
+	return json.Unmarshal(b, out)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
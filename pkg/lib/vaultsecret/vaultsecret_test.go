@@ -0,0 +1,231 @@
+package vaultsecret
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bcaldwell/kube-deploy/pkg/lib/secretprovider"
+)
+
+// fakeVault serves kv v1 and v2 secrets and token, approle and kubernetes logins
+func fakeVault(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	tokens := map[string]bool{"root-token": true}
+
+	login := func(w http.ResponseWriter, token string) {
+		tokens[token] = true
+		writeJSON(w, map[string]interface{}{"auth": map[string]string{"client_token": token}})
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/auth/approle/login":
+			if body["role_id"] != "role" || body["secret_id"] != "secret" {
+				vaultError(w, http.StatusBadRequest, "invalid role or secret ID")
+				return
+			}
+
+			login(w, "approle-token")
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/auth/k8s/login":
+			if body["role"] != "deployer" || body["jwt"] != "sa-jwt" {
+				vaultError(w, http.StatusForbidden, "permission denied")
+				return
+			}
+
+			login(w, "kubernetes-token")
+		case !tokens[r.Header.Get("X-Vault-Token")]:
+			vaultError(w, http.StatusForbidden, "permission denied")
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/secret/data/app/db":
+			if r.Header.Get("X-Vault-Namespace") != "team" {
+				vaultError(w, http.StatusNotFound, "no handler for route")
+				return
+			}
+
+			writeJSON(w, map[string]interface{}{
+				"data": map[string]interface{}{
+					"data":     map[string]interface{}{"password": "hunter2", "port": 5432},
+					"metadata": map[string]interface{}{"version": 3},
+				},
+			})
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/kv/app/db":
+			writeJSON(w, map[string]interface{}{"data": map[string]interface{}{"password": "v1-password"}})
+		default:
+			vaultError(w, http.StatusNotFound, "")
+		}
+	}))
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func vaultError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+
+	errors := []string{}
+	if message != "" {
+		errors = append(errors, message)
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string][]string{"errors": errors})
+}
+
+// setEnv sets env variables, empty values unset them, and returns a func restoring the previous values
+func setEnv(env map[string]string) func() {
+	previous := make(map[string]*string)
+
+	for k, v := range env {
+		if old, ok := os.LookupEnv(k); ok {
+			previous[k] = &old
+		} else {
+			previous[k] = nil
+		}
+
+		if v == "" {
+			os.Unsetenv(k)
+		} else {
+			os.Setenv(k, v)
+		}
+	}
+
+	return func() {
+		for k, v := range previous {
+			if v == nil {
+				os.Unsetenv(k)
+			} else {
+				os.Setenv(k, *v)
+			}
+		}
+	}
+}
+
+func readSecret(t *testing.T, p *Provider, config string) map[string]string {
+	t.Helper()
+
+	secrets, err := p.Secrets(secretprovider.Source{Name: "db", Namespace: "web", Config: json.RawMessage(config)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(secrets) != 1 || secrets[0].Name != "db" || secrets[0].Namespace != "web" {
+		t.Fatalf("expected the db secret in web, got %+v", secrets)
+	}
+
+	data := make(map[string]string)
+	for k, v := range secrets[0].Data {
+		data[k] = string(v)
+	}
+
+	return data
+}
+
+func TestKVv2WithTokenAuth(t *testing.T) {
+	server := fakeVault(t)
+	defer server.Close()
+
+	defer setEnv(map[string]string{"VAULT_TOKEN": "root-token"})()
+
+	p := New(Config{Address: server.URL, Namespace: "team"})
+
+	data := readSecret(t, p, `{"path": "app/db", "keys": {"DB_PASSWORD": "password", "DB_PORT": "port"}}`)
+
+	if data["DB_PASSWORD"] != "hunter2" || data["DB_PORT"] != "5432" || len(data) != 2 {
+		t.Errorf("unexpected secret data %v", data)
+	}
+}
+
+func TestKVv1WithAppRoleAuth(t *testing.T) {
+	server := fakeVault(t)
+	defer server.Close()
+
+	defer setEnv(map[string]string{"VAULT_TOKEN": "", "VAULT_ROLE_ID": "role", "VAULT_SECRET_ID": "secret"})()
+
+	p := New(Config{Address: server.URL, Auth: AuthConfig{Method: AuthAppRole}})
+
+	data := readSecret(t, p, `{"mount": "kv", "path": "app/db", "version": 1}`)
+
+	if data["password"] != "v1-password" {
+		t.Errorf("unexpected secret data %v", data)
+	}
+
+	if p.token != "approle-token" {
+		t.Errorf("expected the approle token to be used, got %q", p.token)
+	}
+}
+
+func TestKubernetesAuth(t *testing.T) {
+	server := fakeVault(t)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	tokenPath := filepath.Join(dir, "token")
+
+	err = ioutil.WriteFile(tokenPath, []byte("sa-jwt\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer setEnv(map[string]string{"VAULT_TOKEN": ""})()
+
+	// kubernetes auth is picked when there is no VAULT_TOKEN and the service account token exists
+	p := New(Config{
+		Address:   server.URL,
+		Namespace: "team",
+		Auth:      AuthConfig{Mount: "k8s", Role: "deployer", ServiceAccountTokenPath: tokenPath},
+	})
+
+	data := readSecret(t, p, `{"path": "app/db"}`)
+
+	if data["password"] != "hunter2" {
+		t.Errorf("unexpected secret data %v", data)
+	}
+
+	if p.token != "kubernetes-token" {
+		t.Errorf("expected the kubernetes token to be used, got %q", p.token)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	server := fakeVault(t)
+	defer server.Close()
+
+	defer setEnv(map[string]string{"VAULT_TOKEN": "", "VAULT_ROLE_ID": "role", "VAULT_SECRET_ID": "wrong"})()
+
+	p := New(Config{Address: server.URL, Auth: AuthConfig{Method: AuthAppRole}})
+
+	_, err := p.Secrets(secretprovider.Source{Name: "db", Config: json.RawMessage(`{"path": "app/db"}`)})
+	if err == nil || !strings.Contains(err.Error(), "invalid role or secret ID") {
+		t.Errorf("expected the vault login error, got %v", err)
+	}
+
+	os.Setenv("VAULT_TOKEN", "root-token")
+
+	p = New(Config{Address: server.URL, Namespace: "team"})
+
+	_, err = p.Secrets(secretprovider.Source{Name: "db", Config: json.RawMessage(`{"path": "app/db", "keys": {"A": "missing"}}`)})
+	if err == nil || !strings.Contains(err.Error(), "key missing not found") {
+		t.Errorf("expected a missing key error, got %v", err)
+	}
+
+	_, err = p.Secrets(secretprovider.Source{Name: "db", Config: json.RawMessage(`{"path": "app/other"}`)})
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}